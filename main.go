@@ -6,39 +6,60 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
+	"html"
 	"image/color"
 	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
 	"time"
+	"unicode/utf8"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	xwidget "fyne.io/x/fyne/widget"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
@@ -46,38 +67,222 @@ import (
 )
 
 type BenchmarkResult struct {
-	ModelName       string              `json:"model_name"`
-	Timestamp       int64               `json:"timestamp"`
-	Duration        float64             `json:"duration"`
-	TokensPerSecond float64             `json:"tokens_per_second"`
-	EvalCount       int                 `json:"eval_count"`
-	EvalDuration    int64               `json:"eval_duration"`
-	Iterations      int                 `json:"iterations"`
-	SysInfo         *SysInfo            `json:"sys_info"`
-	GPUInfo         *GPUInfo            `json:"gpu_info"`
-	OllamaVersion   string              `json:"ollama_version"`
-	ClientType      string              `json:"client_type"`
-	ClientVersion   string              `json:"client_version"`
-	IP              string              `json:"ip"`
-	ProofOfWork     ProofOfWorkSolution `json:"proof_of_work"`
+	ModelName        string              `json:"model_name"`
+	ModelDigest      string              `json:"model_digest"`
+	ModelDetails     *ModelDetails       `json:"model_details"`
+	Prompt           string              `json:"prompt"`
+	PromptHash       string              `json:"prompt_hash"`
+	WarmupPrompt     string              `json:"warmup_prompt"`
+	WarmupPromptHash string              `json:"warmup_prompt_hash"`
+	WarmupRuns       int                 `json:"warmup_runs,omitempty"`
+	PromptSetHash    string              `json:"prompt_set_hash,omitempty"`
+	TotalTokens      int                 `json:"total_tokens,omitempty"`
+	Threads          int                 `json:"threads,omitempty"`
+	NumPredict       int                 `json:"num_predict,omitempty"`
+	Seed             *int                `json:"seed,omitempty"`
+	Temperature      *float64            `json:"temperature,omitempty"`
+	NumCtx           int                 `json:"num_ctx,omitempty"`
+	Format           string              `json:"format,omitempty"`
+	Chat             bool                `json:"chat,omitempty"`
+	DurationTarget   float64             `json:"duration_target,omitempty"`
+	GenerationTPS    []float64           `json:"generation_tps,omitempty"`
+	MinTPS           float64             `json:"min_tps,omitempty"`
+	MaxTPS           float64             `json:"max_tps,omitempty"`
+	StdDev           float64             `json:"stddev,omitempty"`
+	Timestamp        int64               `json:"timestamp"`
+	Duration         float64             `json:"duration"`
+	TokensPerSecond  float64             `json:"tokens_per_second"`
+	EvalCount        int                 `json:"eval_count"`
+	EvalDuration     int64               `json:"eval_duration"`
+	Iterations       int                 `json:"iterations"`
+	SysInfo          *SysInfo            `json:"sys_info"`
+	GPUInfo          *GPUInfo            `json:"gpu_info"`
+	GPUs             []GPUInfo           `json:"gpus,omitempty"`
+	OllamaVersion    string              `json:"ollama_version"`
+	ClientType       string              `json:"client_type"`
+	ClientVersion    string              `json:"client_version"`
+	IP               string              `json:"ip"`
+	ProofOfWork      ProofOfWorkSolution `json:"proof_of_work"`
+	StreamComparison *StreamComparison   `json:"stream_comparison,omitempty"`
+	MachineID        string              `json:"machine_id"`
+	Environment      string              `json:"environment"`
+	Degraded         bool                `json:"degraded,omitempty"`
+	Suspicious       bool                `json:"suspicious,omitempty"`
+	Tags             []string            `json:"tags,omitempty"`
+
+	// Cancelled results stopped early, only CompletedIterations of Iterations were measured
+	Cancelled           bool `json:"cancelled,omitempty"`
+	CompletedIterations int  `json:"completed_iterations"`
+
+	// TotalDurationSec is the wall time of the measured generations only, SetupDurationSec the time
+	// before them: system info, model pull, digest check and warmup. Duration equals TotalDurationSec.
+	TotalDurationSec float64 `json:"total_duration_sec"`
+	SetupDurationSec float64 `json:"setup_duration_sec"`
+
+	// Timing split reported by Ollama, summed over the measured generations in nanoseconds.
+	// PromptTokensPerSecond is the prompt processing (prefill) speed, TokensPerSecond the generation speed.
+	PromptEvalCount       int     `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration    int64   `json:"prompt_eval_duration,omitempty"`
+	LoadDuration          int64   `json:"load_duration,omitempty"`
+	OllamaTotalDuration   int64   `json:"ollama_total_duration,omitempty"`
+	PromptTokensPerSecond float64 `json:"prompt_tokens_per_second,omitempty"`
+}
+
+// StreamComparison holds the client-observed throughput of streamed and non-streamed
+// generations of the same prompt, the difference being the streaming overhead
+type StreamComparison struct {
+	StreamTokensPerSecond    float64 `json:"stream_tokens_per_second"`
+	NonStreamTokensPerSecond float64 `json:"non_stream_tokens_per_second"`
+	OverheadPercent          float64 `json:"overhead_percent"`
 }
 
 type OllamaRequest struct {
-	ModelName string `json:"model"`
-	Prompt    string `json:"prompt"`
+	ModelName string                 `json:"model"`
+	Prompt    string                 `json:"prompt"`
+	Stream    *bool                  `json:"stream,omitempty"`
+	Options   map[string]interface{} `json:"options,omitempty"`
+	Format    string                 `json:"format,omitempty"`
+	// Chat sends the request to /api/chat with the prompt as a single user message
+	Chat bool `json:"-"`
+}
+
+// ChatMessage is a message of an Ollama /api/chat conversation
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// OllamaChatRequest is the /api/chat form of an OllamaRequest
+type OllamaChatRequest struct {
+	ModelName string                 `json:"model"`
+	Messages  []ChatMessage          `json:"messages"`
+	Stream    *bool                  `json:"stream,omitempty"`
+	Options   map[string]interface{} `json:"options,omitempty"`
+	Format    string                 `json:"format,omitempty"`
+}
+
+// path returns the Ollama API path the request is sent to
+func (r OllamaRequest) path() string {
+	if r.Chat {
+		return "/api/chat"
+	}
+	return "/api/generate"
+}
+
+// body returns the JSON body of the request for its endpoint
+func (r OllamaRequest) body() []byte {
+	if !r.Chat {
+		data, _ := json.Marshal(r)
+		return data
+	}
+	data, _ := json.Marshal(OllamaChatRequest{
+		ModelName: r.ModelName,
+		Messages:  []ChatMessage{{Role: "user", Content: r.Prompt}},
+		Stream:    r.Stream,
+		Options:   r.Options,
+		Format:    r.Format,
+	})
+	return data
 }
 
+// Prompt used for every benchmark generation unless the fleet config replaces it
+const defaultPrompt = "Tell me about Llamas in 500 words."
+
+// Appended to the prompt in JSON mode, without it models tend to generate endless whitespace
+const jsonPromptSuffix = " Respond in JSON."
+
+// How often the CLI rewrites the running tokens per second of an iteration
+const liveRateInterval = 200 * time.Millisecond
+
+// Tokens generated per prompt in prompt-set mode, fixed so every prompt weighs the same
+const promptSetNumPredict = 256
+
+// Prompt used for the unmeasured generation that loads the model before benchmarking
+const defaultWarmupPrompt = "Hi"
+
 type ModelRequest struct {
 	Name string `json:"name"`
 }
 
+// OllamaModel is a locally installed model as listed by Ollama's /api/tags
+type OllamaModel struct {
+	Name       string `json:"name"`
+	Model      string `json:"model"`
+	ModifiedAt string `json:"modified_at"`
+	Size       int64  `json:"size"`
+	Digest     string `json:"digest"`
+}
+
+// OllamaRunningModel is a model currently loaded by Ollama as listed by /api/ps
+type OllamaRunningModel struct {
+	Name      string `json:"name"`
+	Model     string `json:"model"`
+	Size      int64  `json:"size"`
+	SizeVRAM  int64  `json:"size_vram"`
+	Digest    string `json:"digest"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// ModelDetails describes the benchmarked model as reported by Ollama's /api/show
+type ModelDetails struct {
+	Format            string `json:"format"`
+	Family            string `json:"family"`
+	ParameterSize     string `json:"parameter_size"`
+	QuantizationLevel string `json:"quantization_level"`
+	ContextLength     int    `json:"context_length"`
+	Template          string `json:"template"`
+}
+
+// BenchmarkOptions holds the settings for a CLI benchmark run
+type BenchmarkOptions struct {
+	ModelName     string
+	Submit        bool
+	OllamaAPI     string
+	Iterations    int
+	Digest        string        // Expected model digest, empty to accept any
+	CompareStream bool          // Also measure non-streaming throughput to quantify streaming overhead
+	WarmupPrompt  string        // Prompt for the unmeasured warmup generation, empty to skip warmup
+	Warmup        int           // Discarded runs of the benchmark prompt before the measured iterations
+	PromptSet     string        // File with one prompt per line, each generated once instead of the iterations
+	UploadS3      bool          // Also store the result in the S3-compatible bucket configured by OLLAMARK_S3_*
+	Output        string        // File to save the result JSON to, e.g. as a baseline for "ollamark regress"
+	Save          bool          // Append the result to the local history read by "ollamark log"
+	CSV           string        // CSV file to append the result to as one row, empty to disable
+	TotalTokens   int           // Generate until this many tokens instead of a fixed number of iterations, 0 to disable
+	Duration      time.Duration // Generate back to back for this long instead of a fixed number of iterations, 0 to disable
+	Tags          []string      // Free-form labels describing the conditions of the run, e.g. "laptop-battery"
+	Threads       int           // Ollama num_thread for CPU inference, 0 for Ollama's default
+	NumPredict    int           // Ollama num_predict, the tokens generated per iteration, 0 to let the model decide
+	Seed          *int          // Ollama seed for deterministic generations, nil for Ollama's default
+	Temperature   *float64      // Ollama temperature, nil for the model's default
+	NumCtx        int           // Ollama num_ctx context size, 0 for the model's default
+	Chat          bool          // Benchmark /api/chat with the prompt as a user message instead of /api/generate
+	FormatJSON    bool          // Constrain generation to JSON with Ollama's format "json"
+	MinTokens     int           // Fewest tokens the first iteration has to generate for the benchmark to continue
+	Force         bool          // Continue even if the first iteration looks broken
+	NoPull        bool          // Never pull the model, even if it isn't installed
+	ConfigURL     string        // Fleet config the models and prompt came from, empty for the defaults
+	JSON          bool          // Print the result as JSON to stdout instead of progress dots
+	Quiet         bool          // Print only the average tokens per second, or only the JSON with JSON
+	Prompt        string        // Prompt of the measured iterations, empty for the default or fleet config prompt
+}
+
 type OllamaResponse struct {
-	Model        string `json:"model"`
-	CreatedAt    string `json:"created_at"`
-	Response     string `json:"response"`
-	Done         bool   `json:"done"`
-	EvalCount    int    `json:"eval_count"`
-	EvalDuration int64  `json:"eval_duration"`
+	Model     string `json:"model"`
+	CreatedAt string `json:"created_at"`
+	Response  string `json:"response"`
+	// Message carries the generated text instead of Response in /api/chat responses
+	Message      *ChatMessage `json:"message,omitempty"`
+	Done         bool         `json:"done"`
+	EvalCount    int          `json:"eval_count"`
+	EvalDuration int64        `json:"eval_duration"`
+	// Prompt processing (prefill) and overall timing, in nanoseconds like EvalDuration
+	PromptEvalCount    int   `json:"prompt_eval_count"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration"`
+	TotalDuration      int64 `json:"total_duration"`
+	LoadDuration       int64 `json:"load_duration"`
+	// Partial is set when the stream broke off and the eval metrics are estimated
+	Partial bool `json:"-"`
 }
 
 type SysInfo struct {
@@ -91,11 +296,21 @@ type SysInfo struct {
 }
 
 type GPUInfo struct {
-	Name          string `json:"name"`
-	Vendor        string `json:"vendor"`
-	Memory        string `json:"memory"`
-	DriverVersion string `json:"driver_version"`
-	Count         int    `json:"count"`
+	Name          string    `json:"name"`
+	Vendor        string    `json:"vendor"`
+	Memory        string    `json:"memory"`
+	DriverVersion string    `json:"driver_version"`
+	Count         int       `json:"count"`
+	Usage         *GPUUsage `json:"usage,omitempty"`
+	Devices       []GPUInfo `json:"devices,omitempty"` // Each GPU when Count GPUs are combined, e.g. several NVIDIA GPUs
+}
+
+// GPUUsage holds GPU utilization and clocks averaged over the benchmark generations
+type GPUUsage struct {
+	UtilizationPercent float64 `json:"utilization_percent"`
+	SMClockMHz         float64 `json:"sm_clock_mhz"`
+	MemoryClockMHz     float64 `json:"memory_clock_mhz"`
+	Samples            int     `json:"samples"`
 }
 
 var (
@@ -104,11 +319,128 @@ var (
 	clientVersion = "0.0.1"
 )
 
+// Default time allowed to establish a connection to Ollama
+const defaultConnectTimeout = 10 * time.Second
+
+var (
+	// ollamaClient is used for every request to the Ollama API
+	ollamaClient = newOllamaClient(defaultConnectTimeout)
+	// requestTimeout bounds each Ollama request including reading the response, 0 means no limit
+	requestTimeout time.Duration
+	// tpsPrecision is the number of decimals of tokens per second in the CLI output
+	tpsPrecision = 2
+	// gpuSelector picks the GPU used for inference by name or vendor when several are detected
+	gpuSelector string
+	// debugResponses receives a copy of every raw /api/generate response stream, nil to disable
+	debugResponses io.Writer
+	// benchmarkPrompt is generated in every measured iteration
+	benchmarkPrompt = defaultPrompt
+	// messages receives the CLI status messages, stderr with -json so stdout only carries the result
+	messages io.Writer = os.Stdout
+	// errorMessages receives the CLI errors that don't stop the run, stderr with -q so they aren't silenced
+	errorMessages io.Writer = os.Stdout
+)
+
+// newOllamaClient returns a client that fails fast when Ollama can't be reached,
+// without limiting how long an established request may take
+func newOllamaClient(connectTimeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout}).DialContext
+	return &http.Client{Transport: transport}
+}
+
+// debugBody copies the generate response stream to debugResponses as it is read
+func debugBody(body io.Reader) io.Reader {
+	if debugResponses == nil {
+		return body
+	}
+	return io.TeeReader(body, debugResponses)
+}
+
+// requestTimeoutError reports an Ollama request that took longer than requestTimeout
+type requestTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e requestTimeoutError) Error() string {
+	return fmt.Sprintf("Ollama didn't complete the request within %s, aborted (raise the limit with -timeout, 0 for none)", e.timeout)
+}
+
+// cancelOnClose releases the request context once the response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel   context.CancelFunc
+	timedOut func() bool
+}
+
+// Read reports a stream cut off by requestTimeout as such, not as a broken stream
+func (b cancelOnClose) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.timedOut() {
+		err = requestTimeoutError{timeout: requestTimeout}
+	}
+	return n, err
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// ollamaRequest sends a request to the Ollama API bounded by requestTimeout,
+// aborting it when parent is cancelled
+func ollamaRequest(parent context.Context, method, url string, body []byte) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(parent)
+	if requestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, requestTimeout)
+	}
+	// Only our own deadline counts as a timeout, not the expiry or cancellation of parent
+	timedOut := func() bool {
+		return requestTimeout > 0 && parent.Err() == nil && ctx.Err() == context.DeadlineExceeded
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := ollamaClient.Do(req)
+	if err != nil {
+		if timedOut() {
+			err = requestTimeoutError{timeout: requestTimeout}
+		}
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel, timedOut: timedOut}
+	return resp, nil
+}
+
+func ollamaGet(url string) (*http.Response, error) {
+	return ollamaRequest(context.Background(), http.MethodGet, url, nil)
+}
+
+func ollamaPost(url string, body []byte) (*http.Response, error) {
+	return ollamaRequest(context.Background(), http.MethodPost, url, body)
+}
+
+func ollamaPostContext(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	return ollamaRequest(ctx, http.MethodPost, url, body)
+}
+
 // ProofOfWorkChallenge represents a proof-of-work challenge
 type ProofOfWorkChallenge struct {
 	Challenge  string `json:"challenge"`
 	Difficulty int    `json:"difficulty"`
 	Timestamp  int64  `json:"timestamp"`
+	Signature  string `json:"signature"`
+	// Unix time the server stops accepting the challenge, zero from older servers
+	ExpiresAt int64 `json:"expires_at"`
 }
 
 // ProofOfWorkSolution represents a solution to a proof-of-work challenge
@@ -117,6 +449,7 @@ type ProofOfWorkSolution struct {
 	Nonce      string `json:"nonce"`
 	Timestamp  int64  `json:"timestamp"`
 	Difficulty int    `json:"difficulty"`
+	Signature  string `json:"signature"`
 }
 
 // requestProofOfWorkChallenge requests a new proof-of-work challenge from the server
@@ -140,27 +473,132 @@ func requestProofOfWorkChallenge(apiEndpoint string) (ProofOfWorkChallenge, erro
 	return challenge, nil
 }
 
-// solveProofOfWork solves the proof-of-work challenge
-func solveProofOfWork(challenge ProofOfWorkChallenge) (string, error) {
+// requestProofOfWorkDifficulty fetches the server's current proof-of-work difficulty
+// without issuing a challenge
+func requestProofOfWorkDifficulty(apiEndpoint string) (int, error) {
+	resp, err := http.Get(apiEndpoint + "/api/pow-difficulty")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, parseAPIError(resp)
+	}
+
+	var result struct {
+		Difficulty int `json:"difficulty"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.Difficulty, nil
+}
+
+// estimateProofOfWorkTime estimates how long solving a challenge of the given difficulty
+// takes on this machine, from the expected 16^difficulty hashes and a short hash rate sample
+// scaled by the solver's one worker per CPU
+func estimateProofOfWorkTime(difficulty int) time.Duration {
+	const samples = 100000
+	start := time.Now()
+	for i := 0; i < samples; i++ {
+		hash := sha256.Sum256([]byte("ollamark" + strconv.Itoa(i)))
+		hex.EncodeToString(hash[:])
+	}
+	hashesPerSecond := samples / time.Since(start).Seconds() * float64(runtime.NumCPU())
+	return time.Duration(math.Pow(16, float64(difficulty)) / hashesPerSecond * float64(time.Second))
+}
+
+// powProgressInterval is how often solveProofOfWork reports its progress
+const powProgressInterval = 250 * time.Millisecond
+
+// powProgressBatch is how many nonces a proof-of-work worker tries between counter updates
+const powProgressBatch = 10000
+
+// solveProofOfWork solves the proof-of-work challenge with one worker per CPU, worker k
+// tries the nonces k, k+N, k+2N... until one of them finds a solution. If progress is not
+// nil, it is called about every powProgressInterval with the attempts so far and the
+// elapsed time.
+func solveProofOfWork(challenge ProofOfWorkChallenge, progress func(attempts int, elapsed time.Duration)) (string, error) {
 	prefix := strings.Repeat("0", challenge.Difficulty)
-	for i := 0; ; i++ {
-		nonce := strconv.Itoa(i)
-		hash := sha256.Sum256([]byte(challenge.Challenge + nonce))
-		if strings.HasPrefix(hex.EncodeToString(hash[:]), prefix) {
-			return nonce, nil
+	workers := runtime.NumCPU()
+	start := time.Now()
+
+	var attempts int64
+	var once sync.Once
+	var solution string
+	done := make(chan struct{})
+	for k := 0; k < workers; k++ {
+		go func(k int) {
+			for i := k; ; i += workers {
+				// Checking the channel on every attempt would slow the solve down
+				if (i-k)/workers%powProgressBatch == 0 && i != k {
+					atomic.AddInt64(&attempts, powProgressBatch)
+					select {
+					case <-done:
+						return
+					default:
+					}
+				}
+				nonce := strconv.Itoa(i)
+				hash := sha256.Sum256([]byte(challenge.Challenge + nonce))
+				if strings.HasPrefix(hex.EncodeToString(hash[:]), prefix) {
+					once.Do(func() {
+						solution = nonce
+						close(done)
+					})
+					return
+				}
+			}
+		}(k)
+	}
+
+	ticker := time.NewTicker(powProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return solution, nil
+		case now := <-ticker.C:
+			if progress != nil {
+				progress(int(atomic.LoadInt64(&attempts)), now.Sub(start))
+			}
 		}
 	}
 }
 
+// powProgressText describes the proof-of-work progress for the GUI and CLI
+func powProgressText(attempts int, elapsed time.Duration) string {
+	return fmt.Sprintf("Solving proof-of-work: %d attempts (%.0f/s), %s elapsed", attempts, float64(attempts)/elapsed.Seconds(), elapsed.Round(time.Second))
+}
+
 type ModelInfo struct {
 	Name         string `json:"name"`
 	Parameters   string `json:"parameters"`
 	Quantization string `json:"quantization"`
 }
 
-func fetchModels() ([]ModelInfo, error) {
-	mainURL := os.Getenv("OLLAMARK_API")
-	resp, err := http.Get(mainURL + "/api/model-list")
+// Public Ollamark API, used when OLLAMARK_API is not set
+const defaultOllamarkAPI = "https://ollamark.com"
+
+// ollamarkAPIOverride is the Ollamark API endpoint given with -api, it takes precedence
+// over OLLAMARK_API
+var ollamarkAPIOverride string
+
+// ollamarkAPI returns the Ollamark API endpoint: -api, OLLAMARK_API or defaultOllamarkAPI
+func ollamarkAPI() string {
+	if ollamarkAPIOverride != "" {
+		return strings.TrimSuffix(ollamarkAPIOverride, "/")
+	}
+	if endpoint := os.Getenv("OLLAMARK_API"); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/")
+	}
+	return defaultOllamarkAPI
+}
+
+// fetchModels returns the models supported by the Ollamark API at apiURL
+func fetchModels(apiURL string) ([]ModelInfo, error) {
+	resp, err := http.Get(apiURL + "/api/model-list")
 	if err != nil {
 		return nil, err
 	}
@@ -183,7 +621,7 @@ func fetchModels() ([]ModelInfo, error) {
 }
 
 func initModels() error {
-	models, err := fetchModels()
+	models, err := fetchModels(ollamarkAPI())
 	if err != nil {
 		return err
 	}
@@ -191,6 +629,121 @@ func initModels() error {
 	return nil
 }
 
+// modelListOffline is set when the Ollamark model list couldn't be fetched. The locally
+// installed models are benchmarked instead and results can't be submitted.
+var modelListOffline bool
+
+// initOllamarkModels prints the Ollamark API in use and fetches its model list. If it can't
+// be reached, local benchmarking still works with useLocalModels.
+func initOllamarkModels() {
+	fmt.Fprintln(os.Stderr, "Ollamark API:", ollamarkAPI())
+	if err := initModels(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to fetch the Ollamark model list:", err)
+		fmt.Fprintln(os.Stderr, "Continuing offline with the locally installed models, results can't be submitted")
+		modelListOffline = true
+	}
+}
+
+// useLocalModels lists the models installed on the Ollama instance as the supported models
+// when the Ollamark model list couldn't be fetched and no fleet config replaced it
+func useLocalModels(ollamaAPI string) {
+	if !modelListOffline || globalModels != nil {
+		return
+	}
+	localModels, err := fetchLocalModels(ollamaAPI)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to list the local models:", err)
+		return
+	}
+	for _, model := range localModels {
+		globalModels = append(globalModels, ModelInfo{Name: model.Name})
+	}
+}
+
+// errModelListOffline is returned when submitting without the Ollamark model list
+var errModelListOffline = errors.New("can't submit, the Ollamark model list couldn't be fetched so the model can't be validated")
+
+// FleetConfig is the centrally managed configuration fetched from -config-url, so a
+// fleet's benchmark parameters can change without redeploying the clients
+type FleetConfig struct {
+	Models   []ModelInfo                  `json:"models,omitempty"`   // Replaces the model list of the Ollamark API
+	Prompt   string                       `json:"prompt,omitempty"`   // Replaces the default benchmark prompt
+	Profiles map[string]map[string]string `json:"profiles,omitempty"` // Flag values by flag name without the dash, selected with -profile
+}
+
+// fleetConfigCachePath returns the local copy of the config last fetched from configURL,
+// in the user's ollamark config directory
+func fleetConfigCachePath(configURL string) string {
+	name := "config-" + promptHash(configURL)[:16] + ".json"
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "ollamark-" + name
+	}
+	return filepath.Join(configDir, "ollamark", name)
+}
+
+func fetchFleetConfig(configURL string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(configURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// loadFleetConfig fetches the config from configURL and caches it. If the URL can't be
+// reached, the cached copy of the last successful fetch is used instead.
+func loadFleetConfig(configURL string) (*FleetConfig, error) {
+	cachePath := fleetConfigCachePath(configURL)
+	data, err := fetchFleetConfig(configURL)
+	fetched := err == nil
+	if !fetched {
+		cached, cacheErr := os.ReadFile(cachePath)
+		if cacheErr != nil {
+			return nil, fmt.Errorf("fetching config from %s: %v (no cached copy)", configURL, err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: fetching config from %s failed (%v), using the cached copy %s\n", configURL, err, cachePath)
+		data = cached
+	}
+
+	var config FleetConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid config from %s: %v", configURL, err)
+	}
+
+	// Only a valid config replaces the cached one
+	if fetched {
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err == nil {
+			err = os.WriteFile(cachePath, data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: caching config:", err)
+		}
+	}
+	return &config, nil
+}
+
+// applyProfile sets the flags of the named profile, flags given on the command line win
+func applyProfile(config *FleetConfig, name string, explicit map[string]bool) error {
+	values, ok := config.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile %q not found in the config", name)
+	}
+	for flagName, value := range values {
+		if explicit[flagName] {
+			continue
+		}
+		if err := flag.Set(flagName, value); err != nil {
+			return fmt.Errorf("profile %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
 func LoadPublicKey() (*rsa.PublicKey, error) {
 	publicKeyData := os.Getenv("PUBLIC_KEY")
 	block, _ := pem.Decode([]byte(publicKeyData))
@@ -256,9 +809,31 @@ func generateUUID() string {
 	return uuid.New().String()
 }
 
-// Sign the UUID with HMAC-SHA256
+// hmacHash returns the hash of the submission signature, HMAC_ALGORITHM sha256 (default)
+// or sha512 like the server
+func hmacHash() func() hash.Hash {
+	if os.Getenv("HMAC_ALGORITHM") == "sha512" {
+		return sha512.New
+	}
+	return sha256.New
+}
+
+// jwtSigningMethod returns the JWT algorithm, JWT_ALGORITHM HS256 (default), HS384 or HS512
+// like the server
+func jwtSigningMethod() jwt.SigningMethod {
+	switch os.Getenv("JWT_ALGORITHM") {
+	case "HS384":
+		return jwt.SigningMethodHS384
+	case "HS512":
+		return jwt.SigningMethodHS512
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// Sign the UUID with HMAC using the hash from hmacHash
 func signUUID(uuid string, secretKey string) string {
-	h := hmac.New(sha256.New, []byte(secretKey))
+	h := hmac.New(hmacHash(), []byte(secretKey))
 	h.Write([]byte(uuid))
 	return base64.StdEncoding.EncodeToString(h.Sum(nil))
 }
@@ -271,29 +846,62 @@ func getCPUName() string {
 		if err != nil {
 			return "Unknown"
 		}
-		lines := strings.Split(string(output), "\n")
-		if len(lines) > 1 {
-			return strings.TrimSpace(lines[1])
+		return parseWMICCPUName(string(output))
+	}
+
+	// get macOS cpu name, Apple Silicon is refined by getSysInfo
+	if runtime.GOOS == "darwin" {
+		output, err := exec.Command("sysctl", "-n", "machdep.cpu.brand_string").Output()
+		if err != nil || strings.TrimSpace(string(output)) == "" {
+			return "Unknown"
 		}
-		return "Unknown"
+		return strings.TrimSpace(string(output))
 	}
 
-	// get linux cpu name
+	// get linux cpu name, from /proc/cpuinfo if lshw isn't installed
 	if runtime.GOOS == "linux" {
-		cmd := exec.Command("lshw", "-C", "cpu")
-		output, err := cmd.Output()
+		if output, err := exec.Command("lshw", "-C", "cpu").Output(); err == nil {
+			if name := parseLshwCPUName(string(output)); name != "Unknown" {
+				return name
+			}
+		}
+		data, err := os.ReadFile("/proc/cpuinfo")
 		if err != nil {
 			return "Unknown"
 		}
-		lines := strings.Split(string(output), "\n")
-		for _, line := range lines {
-			if strings.Contains(line, "product:") {
-				return strings.TrimSpace(strings.Split(line, ":")[1])
-			}
+		return parseCPUInfoModelName(string(data))
+	}
+
+	return "Unknown"
+}
+
+// parseWMICCPUName reads the name below the header of "wmic cpu get name"
+func parseWMICCPUName(output string) string {
+	lines := strings.Split(output, "\n")
+	if len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		return strings.TrimSpace(lines[1])
+	}
+	return "Unknown"
+}
+
+// parseLshwCPUName reads the first product of "lshw -C cpu"
+func parseLshwCPUName(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, "product:") {
+			return strings.TrimSpace(strings.SplitN(line, ":", 2)[1])
 		}
-		return "Unknown"
 	}
+	return "Unknown"
+}
 
+// parseCPUInfoModelName reads the first "model name" of /proc/cpuinfo
+func parseCPUInfoModelName(cpuinfo string) string {
+	for _, line := range strings.Split(cpuinfo, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if found && strings.TrimSpace(key) == "model name" {
+			return strings.TrimSpace(value)
+		}
+	}
 	return "Unknown"
 }
 
@@ -321,6 +929,33 @@ func getKernelVersion() (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
+// detectEnvironment reports whether the client runs in a container ("docker"),
+// under WSL ("wsl") or directly on the host ("bare-metal")
+func detectEnvironment() string {
+	if runtime.GOOS != "linux" {
+		return "bare-metal"
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "docker"
+	}
+	if cgroup, err := os.ReadFile("/proc/1/cgroup"); err == nil {
+		for _, runtimeName := range []string{"docker", "containerd", "kubepods", "libpod"} {
+			if strings.Contains(string(cgroup), runtimeName) {
+				return "docker"
+			}
+		}
+	}
+	if version, err := os.ReadFile("/proc/version"); err == nil && strings.Contains(strings.ToLower(string(version)), "microsoft") {
+		return "wsl"
+	}
+	return "bare-metal"
+}
+
+// userAgent identifies the client version and platform to the Ollamark server
+func userAgent() string {
+	return fmt.Sprintf("ollamark/%s (%s; %s)", clientVersion, runtime.GOOS, runtime.GOARCH)
+}
+
 func getSysInfo() (*SysInfo, error) {
 	v, _ := mem.VirtualMemory()
 	// s, _ := mem.SwapMemory()
@@ -341,7 +976,7 @@ func getSysInfo() (*SysInfo, error) {
 	}
 	sysInfo.Kernel = kernelVersion
 	sysInfo.CPU = strconv.Itoa(runtime.NumCPU())
-	// get CPU Name for Windows and Linux
+	// get CPU Name for Windows, macOS and Linux
 
 	sysInfo.CPUName = getCPUName()
 
@@ -367,6 +1002,80 @@ func getSysInfo() (*SysInfo, error) {
 	return sysInfo, nil
 }
 
+// machineFingerprint identifies a physical machine across runs without relying on its IP.
+// It is the hex SHA-256 of "cpu name|cpu cores|memory|gpu name|gpu memory", so it only
+// changes when the hardware does and can't be reversed into the hardware description.
+func machineFingerprint(sysInfo *SysInfo, gpuInfo *GPUInfo) string {
+	var fields []string
+	if sysInfo != nil {
+		fields = append(fields, sysInfo.CPUName, sysInfo.CPU, sysInfo.Memory)
+	} else {
+		fields = append(fields, "", "", "")
+	}
+	if gpuInfo != nil {
+		fields = append(fields, gpuInfo.Name, gpuInfo.Memory)
+	} else {
+		fields = append(fields, "", "")
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(fields, "|")))
+	return hex.EncodeToString(hash[:])
+}
+
+// parseThreadList parses a comma-separated list of thread counts for -threads-sweep
+func parseThreadList(list string) ([]int, error) {
+	var threads []int
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid thread count %q", field)
+		}
+		threads = append(threads, n)
+	}
+	if len(threads) == 0 {
+		return nil, fmt.Errorf("no thread counts given")
+	}
+	return threads, nil
+}
+
+// parseModelList parses the comma-separated model names of -m
+func parseModelList(list string) ([]string, error) {
+	var models []string
+	for _, field := range strings.Split(list, ",") {
+		name := strings.TrimSpace(field)
+		if name == "" {
+			return nil, fmt.Errorf("invalid model list %q: empty model name", list)
+		}
+		models = append(models, name)
+	}
+	return models, nil
+}
+
+// parseTags splits a comma-separated list of tags, dropping empty entries
+func parseTags(list string) []string {
+	var tags []string
+	for _, tag := range strings.Split(list, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// promptHash returns the hex SHA-256 of a prompt so results can be grouped by prompt,
+// or "" for an empty (unused) prompt
+func promptHash(prompt string) string {
+	if prompt == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(hash[:])
+}
+
 func getMacGPUInfo() (*GPUInfo, error) {
 	cmd := exec.Command("system_profiler", "SPDisplaysDataType")
 	output, err := cmd.Output()
@@ -409,26 +1118,67 @@ func getMacGPUInfo() (*GPUInfo, error) {
 	return gpuInfo, nil
 }
 
+// getGPUInfo returns the GPU used for inference, see selectGPU
 func getGPUInfo() (*GPUInfo, error) {
-	// First, attempt to use nvidia-smi to fetch Nvidia GPU info
+	gpus, err := getAllGPUInfo()
+	if err != nil {
+		return nil, err
+	}
+	return selectGPU(gpus, gpuSelector)
+}
+
+// getAllGPUInfo runs every GPU detector and returns all GPUs found, e.g. both GPUs of a
+// laptop with switchable graphics. Nvidia GPUs come first, then AMD, then Apple, then Intel.
+func getAllGPUInfo() ([]GPUInfo, error) {
+	var gpus []GPUInfo
+
 	nvidiaGPU, err := getNvidiaGPUInfo()
 	if err == nil {
-		return nvidiaGPU, nil
+		gpus = append(gpus, *nvidiaGPU)
 	}
 
-	// If Nvidia GPU info fetching fails, attempt to fetch AMD GPU info
 	amdGPU, err := getAMDGPUInfo()
 	if err == nil {
-		return amdGPU, nil
+		gpus = append(gpus, *amdGPU)
 	}
 
 	// Check if we're on macOS (darwin) and arm64 architecture
 	if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" {
-		return getMacGPUInfo()
+		var macGPU *GPUInfo
+		macGPU, err = getMacGPUInfo()
+		if err == nil {
+			gpus = append(gpus, *macGPU)
+		}
+	}
+
+	var intelGPU *GPUInfo
+	intelGPU, err = getIntelGPUInfo()
+	if err == nil {
+		gpus = append(gpus, *intelGPU)
 	}
 
-	// If both methods fail, return the last error
-	return nil, err
+	// If every method fails, return the last error
+	if len(gpus) == 0 {
+		return nil, err
+	}
+	return gpus, nil
+}
+
+// selectGPU returns the first GPU whose name or vendor contains selector, ignoring case,
+// or the first GPU if selector is empty
+func selectGPU(gpus []GPUInfo, selector string) (*GPUInfo, error) {
+	if selector == "" {
+		return &gpus[0], nil
+	}
+	lowerSelector := strings.ToLower(selector)
+	var names []string
+	for i := range gpus {
+		if strings.Contains(strings.ToLower(gpus[i].Name), lowerSelector) || strings.Contains(strings.ToLower(gpus[i].Vendor), lowerSelector) {
+			return &gpus[i], nil
+		}
+		names = append(names, gpus[i].Name)
+	}
+	return nil, fmt.Errorf("no detected GPU matches %q, detected: %s", selector, strings.Join(names, ", "))
 }
 
 func getNvidiaGPUInfo() (*GPUInfo, error) {
@@ -438,31 +1188,154 @@ func getNvidiaGPUInfo() (*GPUInfo, error) {
 		return nil, err
 	}
 
-	outputStr := strings.TrimSpace(string(output))
-	lines := strings.Split(outputStr, "\n")[0] // Assuming single GPU
-	fields := strings.Split(lines, ",")
+	return parseNvidiaSMIOutput(string(output))
+}
+
+// parseNvidiaSMIOutput parses the name,memory.total,driver_version CSV of nvidia-smi, one
+// line per GPU. Several GPUs are combined into one GPUInfo with the total memory, since
+// Ollama spreads a model across all of them, and listed individually in Devices.
+func parseNvidiaSMIOutput(output string) (*GPUInfo, error) {
+	var devices []GPUInfo
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("failed to parse Nvidia GPU information")
+		}
+
+		// Some older drivers don't report the driver version
+		driverVersion := "Unknown"
+		if len(fields) > 2 && strings.TrimSpace(fields[2]) != "" {
+			driverVersion = strings.TrimSpace(fields[2])
+		}
 
-	if len(fields) < 2 {
+		devices = append(devices, GPUInfo{
+			Name:          strings.TrimSpace(fields[0]),
+			Vendor:        "NVIDIA",
+			Memory:        strings.TrimSpace(fields[1]),
+			DriverVersion: driverVersion,
+			Count:         1,
+		})
+	}
+	if len(devices) == 0 {
 		return nil, fmt.Errorf("failed to parse Nvidia GPU information")
 	}
+	if len(devices) == 1 {
+		return &devices[0], nil
+	}
 
-	return &GPUInfo{
-		Name:          strings.TrimSpace(fields[0]),
-		Vendor:        "NVIDIA",
-		Memory:        strings.TrimSpace(fields[1]),
-		DriverVersion: strings.TrimSpace(fields[2]),
-		Count:         len(lines),
-	}, nil
+	gpu := devices[0]
+	gpu.Count = len(devices)
+	gpu.Devices = devices
+	var totalMemory int64
+	memoryKnown := true
+	for i, device := range devices {
+		if i > 0 && device.Name != devices[0].Name {
+			gpu.Name += " + " + device.Name
+		}
+		memory, ok := parseMemoryBytes(device.Memory)
+		memoryKnown = memoryKnown && ok
+		totalMemory += memory
+	}
+	if memoryKnown {
+		gpu.Memory = fmt.Sprintf("%d MiB", totalMemory>>20)
+	}
+	return &gpu, nil
 }
 
-func getAMDGPUInfo() (*GPUInfo, error) {
-	switch runtime.GOOS {
-	case "windows":
-		return getAMDGPUInfoWindows()
-	case "linux":
-		return getAMDGPUInfoLinux()
-	case "darwin":
-		return nil, fmt.Errorf("macOS, Skipping AMD GPU info")
+// sampleNvidiaGPU reads the current utilization and SM/memory clocks of the first GPU
+func sampleNvidiaGPU() (utilization, smClock, memoryClock float64, err error) {
+	cmd := exec.Command("nvidia-smi", "--query-gpu=utilization.gpu,clocks.sm,clocks.mem", "--format=csv,noheader,nounits")
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	line := strings.Split(strings.TrimSpace(string(output)), "\n")[0]
+	fields := strings.Split(line, ",")
+	if len(fields) < 3 {
+		return 0, 0, 0, fmt.Errorf("failed to parse Nvidia GPU usage")
+	}
+
+	values := make([]float64, 3)
+	for i := range values {
+		values[i], err = strconv.ParseFloat(strings.TrimSpace(fields[i]), 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to parse Nvidia GPU usage: %v", err)
+		}
+	}
+	return values[0], values[1], values[2], nil
+}
+
+// gpuSampler periodically samples GPU usage in the background while benchmarking
+type gpuSampler struct {
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	done     chan struct{}
+	total    GPUUsage
+}
+
+// startGPUSampler starts sampling GPU usage every interval until stop is called.
+// Only NVIDIA GPUs are supported, on other hardware no samples are collected.
+func startGPUSampler(interval time.Duration) *gpuSampler {
+	sampler := &gpuSampler{
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(sampler.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				utilization, smClock, memoryClock, err := sampleNvidiaGPU()
+				if err != nil {
+					return
+				}
+				sampler.total.UtilizationPercent += utilization
+				sampler.total.SMClockMHz += smClock
+				sampler.total.MemoryClockMHz += memoryClock
+				sampler.total.Samples++
+			case <-sampler.stopCh:
+				return
+			}
+		}
+	}()
+
+	return sampler
+}
+
+// stop ends sampling and returns the average usage, or nil if nothing was sampled.
+// It is safe to call more than once.
+func (s *gpuSampler) stop() *GPUUsage {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	<-s.done
+
+	if s.total.Samples == 0 {
+		return nil
+	}
+	samples := float64(s.total.Samples)
+	return &GPUUsage{
+		UtilizationPercent: s.total.UtilizationPercent / samples,
+		SMClockMHz:         s.total.SMClockMHz / samples,
+		MemoryClockMHz:     s.total.MemoryClockMHz / samples,
+		Samples:            s.total.Samples,
+	}
+}
+
+func getAMDGPUInfo() (*GPUInfo, error) {
+	switch runtime.GOOS {
+	case "windows":
+		return getAMDGPUInfoWindows()
+	case "linux":
+		return getAMDGPUInfoLinux()
+	case "darwin":
+		return nil, fmt.Errorf("macOS, Skipping AMD GPU info")
 	default:
 		return nil, fmt.Errorf("AMD GPU unsupported operating system")
 	}
@@ -487,8 +1360,8 @@ func parseWMICOutput(output string) (*GPUInfo, error) {
 	for _, line := range lines {
 		if strings.HasPrefix(line, "Name=") {
 			name := strings.TrimSpace(strings.Split(line, "=")[1])
-			// Skip integrated and virtual GPUs
-			if strings.Contains(name, "Integrated") || strings.Contains(name, "Display Adapter") || strings.Contains(name, "AMD Radeon(TM) Graphics") {
+			// Skip integrated and virtual GPUs, Intel GPUs are detected by getIntelGPUInfo
+			if strings.Contains(name, "Integrated") || strings.Contains(name, "Display Adapter") || strings.Contains(name, "AMD Radeon(TM) Graphics") || strings.Contains(name, "Intel") {
 				continue
 			}
 			if !gpuNames[name] {
@@ -522,7 +1395,12 @@ func getAMDGPUInfoLinux() (*GPUInfo, error) {
 	if strings.Contains(outputStr, "Radeon") || strings.Contains(outputStr, "AMD") {
 		name := extractField(outputStr, "product")
 		// vendor := "AMD"
-		memory := extractField(outputStr, "size")
+		memory := amdVRAMLinux()
+		if memory == "Unknown" {
+			if size := extractField(outputStr, "size"); size != "" {
+				memory = size
+			}
+		}
 
 		return &GPUInfo{
 			Name: name,
@@ -534,6 +1412,144 @@ func getAMDGPUInfoLinux() (*GPUInfo, error) {
 	return nil, fmt.Errorf("no AMD GPU detected")
 }
 
+func getIntelGPUInfo() (*GPUInfo, error) {
+	switch runtime.GOOS {
+	case "windows":
+		cmd := exec.Command("wmic", "path", "win32_VideoController", "get", "Name,DriverVersion", "/format:list")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute wmic command: %v", err)
+		}
+		return parseWMICIntelOutput(string(output))
+	case "linux":
+		cmd := exec.Command("lspci", "-k")
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, err
+		}
+		return parseLspciIntelOutput(string(output))
+	default:
+		return nil, fmt.Errorf("Intel GPU unsupported operating system")
+	}
+}
+
+// intelGPUMemory returns "Shared" for integrated Intel graphics, which use system memory,
+// and "Unknown" for Arc GPUs, whose VRAM the tools don't report
+func intelGPUMemory(name string) string {
+	if strings.Contains(name, "Arc") || strings.Contains(name, "DG2") {
+		return "Unknown"
+	}
+	return "Shared"
+}
+
+// pickIntelGPU returns the dedicated Arc GPU if there is one, otherwise the first
+// integrated GPU, with the number of Intel GPUs found as Count
+func pickIntelGPU(devices []GPUInfo) (*GPUInfo, error) {
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no Intel GPU detected")
+	}
+	gpu := devices[0]
+	for _, device := range devices {
+		if device.Memory != "Shared" {
+			gpu = device
+			break
+		}
+	}
+	gpu.Count = len(devices)
+	return &gpu, nil
+}
+
+// parseWMICIntelOutput parses the Intel adapters listed by wmic, each a block of
+// Key=Value lines ended by a blank line
+func parseWMICIntelOutput(output string) (*GPUInfo, error) {
+	var devices []GPUInfo
+	var name, driverVersion string
+	for _, line := range append(strings.Split(output, "\n"), "") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Name="):
+			name = strings.TrimPrefix(line, "Name=")
+		case strings.HasPrefix(line, "DriverVersion="):
+			driverVersion = strings.TrimPrefix(line, "DriverVersion=")
+		case line == "":
+			if strings.Contains(name, "Intel") {
+				devices = append(devices, GPUInfo{Name: name, Vendor: "Intel", Memory: intelGPUMemory(name), DriverVersion: driverVersion})
+			}
+			name, driverVersion = "", ""
+		}
+	}
+	return pickIntelGPU(devices)
+}
+
+// parseLspciIntelOutput parses the Intel display controllers listed by "lspci -k", with
+// the kernel driver in use (i915 or xe) as their driver version
+func parseLspciIntelOutput(output string) (*GPUInfo, error) {
+	var devices []GPUInfo
+	inDevice := false
+	for _, line := range strings.Split(output, "\n") {
+		// Device lines start at the first column, their details are indented
+		if !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, " ") {
+			isDisplay := strings.Contains(line, "VGA compatible controller") || strings.Contains(line, "Display controller") || strings.Contains(line, "3D controller")
+			inDevice = isDisplay && strings.Contains(line, "Intel")
+			if !inDevice {
+				continue
+			}
+			name := line
+			if i := strings.Index(line, ": "); i >= 0 {
+				name = line[i+2:]
+			}
+			if i := strings.LastIndex(name, " (rev "); i >= 0 {
+				name = name[:i]
+			}
+			name = strings.TrimSpace(name)
+			devices = append(devices, GPUInfo{Name: name, Vendor: "Intel", Memory: intelGPUMemory(name), DriverVersion: "Unknown"})
+		} else if inDevice {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "Kernel driver in use:") {
+				devices[len(devices)-1].DriverVersion = strings.TrimSpace(strings.TrimPrefix(trimmed, "Kernel driver in use:"))
+			}
+		}
+	}
+	return pickIntelGPU(devices)
+}
+
+// amdVRAMLinux returns the VRAM of the first AMD GPU as reported by the amdgpu driver in
+// sysfs, or by rocm-smi if sysfs doesn't have it, and "Unknown" if neither does
+func amdVRAMLinux() string {
+	paths, _ := filepath.Glob("/sys/class/drm/card*/device/mem_info_vram_total")
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if bytes, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil && bytes > 0 {
+			return formatGB(bytes)
+		}
+	}
+
+	if output, err := exec.Command("rocm-smi", "--showmeminfo", "vram").Output(); err == nil {
+		if bytes, ok := parseRocmSMIVRAM(string(output)); ok {
+			return formatGB(bytes)
+		}
+	}
+	return "Unknown"
+}
+
+// parseRocmSMIVRAM reads the first "VRAM Total Memory (B): <bytes>" of rocm-smi --showmeminfo vram
+func parseRocmSMIVRAM(output string) (int64, bool) {
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, "VRAM Total Memory (B)") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		bytes, err := strconv.ParseInt(strings.TrimSpace(fields[len(fields)-1]), 10, 64)
+		if err == nil && bytes > 0 {
+			return bytes, true
+		}
+	}
+	return 0, false
+}
+
 func getIPAddress() string {
 	resp, err := http.Get("https://icanhazip.com")
 	if err != nil {
@@ -558,6 +1574,237 @@ func getOllamaVersion() string {
 	return strings.TrimSpace(strings.Split(string(output), "ollama version is ")[1])
 }
 
+// normalizeEndpoint turns an Ollama endpoint as typed by a user into a clean base URL:
+// it adds a missing http:// scheme and drops any /api/... suffix, query and trailing slash,
+// so "localhost:11434/api/generate/" becomes "http://localhost:11434"
+func normalizeEndpoint(endpoint string) (string, error) {
+	endpoint = strings.TrimSpace(endpoint)
+	if endpoint == "" {
+		return "", fmt.Errorf("the Ollama API endpoint is empty")
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid Ollama API endpoint %q: %v", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid Ollama API endpoint %q: scheme must be http or https", endpoint)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid Ollama API endpoint %q: missing host", endpoint)
+	}
+
+	// Keep a path prefix of a reverse proxy, but not the API path itself
+	path := strings.TrimSuffix(u.Path, "/")
+	if path == "/api" {
+		path = ""
+	} else if i := strings.Index(path, "/api/"); i >= 0 {
+		path = path[:i]
+	}
+	return (&url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host, Path: path}).String(), nil
+}
+
+// Time allowed for the reachability check before a benchmark
+const pingTimeout = 2 * time.Second
+
+// pingOllama checks that the Ollama API at endpoint answers, so a wrong or stopped endpoint
+// is reported before the model pull fails with a less obvious error
+func pingOllama(endpoint string) error {
+	client := &http.Client{Timeout: pingTimeout}
+	resp, err := client.Get(endpoint + "/api/version")
+	if err != nil {
+		return fmt.Errorf("Cannot reach Ollama at %s: %v", endpoint, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Cannot reach Ollama at %s: unexpected status %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+// fetchLocalModels lists the models installed on the Ollama instance
+func fetchLocalModels(ollamaAPI string) ([]OllamaModel, error) {
+	resp, err := ollamaGet(ollamaAPI + "/api/tags")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list local models: %s", body)
+	}
+
+	var result struct {
+		Models []OllamaModel `json:"models"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return result.Models, nil
+}
+
+// modelInstalled reports whether the model is already installed on the Ollama instance, so
+// the pull can be skipped. If the local models can't be listed it reports false.
+func modelInstalled(ollamaAPI, modelName string) bool {
+	localModels, err := fetchLocalModels(ollamaAPI)
+	if err != nil {
+		return false
+	}
+	for _, model := range localModels {
+		if normalizeModelName(model.Name) == normalizeModelName(modelName) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeModelName adds the implicit ":latest" tag Ollama uses for untagged model names
+func normalizeModelName(modelName string) string {
+	if !strings.Contains(modelName, ":") {
+		return modelName + ":latest"
+	}
+	return modelName
+}
+
+// getModelDigest returns the digest of the weights installed locally under modelName
+func getModelDigest(ollamaAPI, modelName string) (string, error) {
+	models, err := fetchLocalModels(ollamaAPI)
+	if err != nil {
+		return "", err
+	}
+
+	name := normalizeModelName(modelName)
+	for _, model := range models {
+		if normalizeModelName(model.Name) == name {
+			return model.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("model %s is not installed", modelName)
+}
+
+// digestMatches reports whether digest matches the expected one, which may be
+// abbreviated (like the ID shown by "ollama list") or carry a "sha256:" prefix
+func digestMatches(digest, expected string) bool {
+	if expected == "" {
+		return true
+	}
+	expected = strings.ToLower(strings.TrimPrefix(expected, "sha256:"))
+	return strings.HasPrefix(strings.TrimPrefix(digest, "sha256:"), expected)
+}
+
+// showModel queries Ollama's /api/show for the details of the installed model
+func showModel(ollamaAPI, modelName string) (*ModelDetails, error) {
+	jsonData, _ := json.Marshal(ModelRequest{Name: modelName})
+	resp, err := ollamaPost(ollamaAPI+"/api/show", jsonData)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to show model %s: %s", modelName, body)
+	}
+
+	var show struct {
+		Template string `json:"template"`
+		Details  struct {
+			Format            string `json:"format"`
+			Family            string `json:"family"`
+			ParameterSize     string `json:"parameter_size"`
+			QuantizationLevel string `json:"quantization_level"`
+		} `json:"details"`
+		ModelInfo map[string]interface{} `json:"model_info"`
+	}
+	if err := json.Unmarshal(body, &show); err != nil {
+		return nil, err
+	}
+
+	details := &ModelDetails{
+		Format:            show.Details.Format,
+		Family:            show.Details.Family,
+		ParameterSize:     show.Details.ParameterSize,
+		QuantizationLevel: show.Details.QuantizationLevel,
+		Template:          show.Template,
+	}
+
+	// The context length is keyed by architecture, e.g. "llama.context_length"
+	if arch, ok := show.ModelInfo["general.architecture"].(string); ok {
+		if contextLength, ok := show.ModelInfo[arch+".context_length"].(float64); ok {
+			details.ContextLength = int(contextLength)
+		}
+	}
+
+	return details, nil
+}
+
+// fetchRunningModels lists the models currently loaded into memory by Ollama
+func fetchRunningModels(ollamaAPI string) ([]OllamaRunningModel, error) {
+	resp, err := ollamaGet(ollamaAPI + "/api/ps")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list running models: %s", body)
+	}
+
+	var result struct {
+		Models []OllamaRunningModel `json:"models"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return result.Models, nil
+}
+
+// checkGPUOffload inspects where Ollama placed the loaded model and returns a
+// warning when a GPU was detected but the model is not (fully) in VRAM, or ""
+// when everything looks right or the placement cannot be determined
+func checkGPUOffload(ollamaAPI, modelName string, gpuInfo *GPUInfo) string {
+	if gpuInfo == nil {
+		return ""
+	}
+
+	models, err := fetchRunningModels(ollamaAPI)
+	if err != nil {
+		return ""
+	}
+
+	name := normalizeModelName(modelName)
+	for _, model := range models {
+		if normalizeModelName(model.Name) == name {
+			return offloadWarning(model)
+		}
+	}
+	return ""
+}
+
+// offloadWarning explains a loaded model not (fully) placed in VRAM despite a detected GPU
+func offloadWarning(model OllamaRunningModel) string {
+	if model.SizeVRAM == 0 {
+		return "Ollama is running CPU-only despite a detected GPU — check your CUDA/ROCm install."
+	}
+	if model.SizeVRAM < model.Size {
+		return fmt.Sprintf("Only %.0f%% of the model is loaded on the GPU; the rest runs on the CPU.", float64(model.SizeVRAM)/float64(model.Size)*100)
+	}
+	return ""
+}
+
 func extractField(data, fieldName string) string {
 	// Simple parsing logic, needs to be adjusted based on actual output
 	start := strings.Index(data, fieldName+":")
@@ -576,23 +1823,42 @@ func main() {
 	// Load environment variables from .env file
 	err := godotenv.Load()
 	if err != nil {
-		fmt.Println("Error loading .env file:", err)
+		fmt.Fprintln(os.Stderr, "Error loading .env file:", err)
 	}
 
-	fmt.Println("Loading Ollamark...")
+	fmt.Fprintln(os.Stderr, "Loading Ollamark...")
 
-	fmt.Println("Checking Ollama Version...")
+	fmt.Fprintln(os.Stderr, "Checking Ollama Version...")
 	ollamaVersion := getOllamaVersion()
 	if ollamaVersion == "Unknown" {
-		fmt.Println("Ollama not found, please install Ollama from https://ollama.com/download to Ollamark 😎")
+		fmt.Fprintln(os.Stderr, "Ollama not found, please install Ollama from https://ollama.com/download to Ollamark 😎")
 		return
 	}
-	fmt.Println("Ollama Version:", ollamaVersion)
+	fmt.Fprintln(os.Stderr, "Ollama Version:", ollamaVersion)
 
-	err = initModels()
-	if err != nil {
-		fmt.Println("Failed to initialize models:", err)
-		return
+	// Subcommands use OLLAMARK_API, the benchmark flags are parsed first since -api overrides it
+	modelsLoaded := false
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		initOllamarkModels()
+		modelsLoaded = true
+
+		switch os.Args[1] {
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "regress":
+			runRegress(os.Args[2:])
+			return
+		case "chart":
+			runChart(os.Args[2:])
+			return
+		case "health":
+			runHealth(os.Args[2:])
+			return
+		case "log":
+			runLog(os.Args[2:])
+			return
+		}
 	}
 
 	flag.Usage = func() {
@@ -602,63 +1868,372 @@ func main() {
 		fmt.Println("Examples:")
 		fmt.Println("  For Ollamark GUI mode:")
 		fmt.Println("      ollamark (no flags)")
+		fmt.Println("      ollamark -assets-dir /usr/local/share/ollamark")
 		fmt.Println("  For Ollamark CLI mode:")
 		fmt.Println("      ollamark -m llama3 -i 10")
 		fmt.Println("      ollamark -m phi3")
 		fmt.Println("      ollamark -m phi3 -s")
 		fmt.Println("      ollamark -m phi3 -s -o http://localhost:11434/api/generate")
+		fmt.Println("      ollamark -m phi3 -json | jq .tokens_per_second")
+		fmt.Println("      ollamark -m llama3,phi3,gemma -i 5")
+		fmt.Println("      ollamark -m phi3 -pf prompts/support-ticket.txt")
+		fmt.Println("  For benchmarking every locally installed model:")
+		fmt.Println("      ollamark -models-from-tags -models-filter \"llama3*\"")
+		fmt.Println("  For finding the fastest CPU thread count:")
+		fmt.Println("      ollamark -m phi3 -threads-sweep 1,2,4,8")
+		fmt.Println("  For comparing against a saved result (e.g. after upgrading Ollama):")
+		fmt.Println("      ollamark -m llama3 -i 5 -out baseline.json")
+		fmt.Println("      ollamark regress -baseline baseline.json")
+		fmt.Println("  For tracking this machine in the local history:")
+		fmt.Println("      ollamark -m llama3 -save")
+		fmt.Println("      ollamark log -model llama3 -last 20")
+		fmt.Println("  For charting saved results:")
+		fmt.Println("      ollamark chart results/*.json -o chart.svg -by model")
+		fmt.Println("  For a pre-flight check of Ollama and this machine:")
+		fmt.Println("      ollamark health -o http://localhost:11434")
+		fmt.Println("  For a centrally managed fleet:")
+		fmt.Println("      ollamark -config-url https://example.com/ollamark.json -profile quick")
+		fmt.Println("  For Ollamark remote benchmark mode:")
+		fmt.Println("      ollamark serve -listen :8080 -token <shared token>")
 	}
 
 	// Parse command-line arguments (Ollamark CLI)
-	modelPtr := flag.String("m", "llama3", "Model name to benchmark (default: llama3)")
+	modelPtr := flag.String("m", "llama3", "Model name to benchmark, or a comma-separated list to compare several (default: llama3)")
 	submitPtr := flag.Bool("s", false, "Submit benchmark results to Ollamark.com (default false)")
 	ollamaPtr := flag.String("o", "http://localhost:11434", "Ollama API endpoint (default http://localhost:11434)")
 	iterationsPtr := flag.Int("i", 2, "Number of benchmark iterations (Min 2, Max 20)")
+	digestPtr := flag.String("digest", "", "Expected model digest, the benchmark aborts if the local model differs")
+	compareStreamPtr := flag.Bool("compare-stream", false, "Also benchmark without streaming and report the streaming overhead")
+	connectTimeoutPtr := flag.Duration("connect-timeout", defaultConnectTimeout, "Time allowed to connect to the Ollama API")
+	requestTimeoutPtr := flag.Duration("request-timeout", 0, "Time allowed for each Ollama request including model loading and generation, 0 for no limit")
+	timeoutPtr := flag.Int("timeout", 0, "Seconds allowed for each Ollama request (model pull and each generation), 0 for no limit, same as -request-timeout")
+	precisionPtr := flag.Int("precision", 2, "Decimal places of tokens per second in the output, saved results keep full precision")
+	formatJSONPtr := flag.Bool("format-json", false, "Constrain generation to JSON (Ollama format \"json\") to measure the throughput of structured output")
+	minTokensPtr := flag.Int("min-tokens", defaultMinTokens, "Fewest tokens the first iteration has to generate, fewer abort the benchmark as broken")
+	forcePtr := flag.Bool("force", false, "Keep benchmarking even if the first iteration looks broken")
+	pullPtr := flag.Bool("pull", true, "Pull the model if it isn't installed yet, -pull=false never pulls")
+	modelsFromTagsPtr := flag.Bool("models-from-tags", false, "Benchmark every model installed in Ollama instead of -m, skipping models too large for the free memory")
+	modelsFilterPtr := flag.String("models-filter", "", "With -models-from-tags, only benchmark models matching this glob, e.g. \"llama3*\"")
+	numPredictPtr := flag.Int("n", 0, "Tokens to generate per iteration (Ollama num_predict), the same for every model so tokens per second compare fairly, 0 to let the model decide")
+	flag.IntVar(numPredictPtr, "num-predict", 0, "Same as -n")
+	seedPtr := flag.Int("seed", 0, "Ollama seed, a fixed seed makes the generated text and token counts repeatable, unset for Ollama's default")
+	tempPtr := flag.Float64("temp", 0, "Ollama temperature, unset for the model's default")
+	ctxPtr := flag.Int("ctx", 0, "Ollama context size (num_ctx), 0 for the model's default")
+	chatPtr := flag.Bool("chat", false, "Benchmark Ollama's /api/chat with the prompt as a single user message instead of /api/generate")
+	threadsPtr := flag.Int("threads", 0, "CPU threads for inference (Ollama num_thread), 0 for Ollama's default")
+	threadsSweepPtr := flag.String("threads-sweep", "", "Comma-separated thread counts to benchmark one after another to find the fastest, e.g. \"1,2,4,8\"")
+	tagsPtr := flag.String("tags", "", "Comma-separated labels for the run's conditions, e.g. \"overclocked,laptop-battery\"")
+	durationPtr := flag.Duration("duration", 0, "Keep generating for this long, e.g. 60s, to measure sustained throughput instead of running a fixed number of iterations")
+	totalTokensPtr := flag.Int("total-tokens", 0, "Keep generating until this many tokens were generated instead of running a fixed number of iterations")
+	savePtr := flag.Bool("save", false, "Append the benchmark result to the local history shown by \"ollamark log\"")
+	csvPtr := flag.String("csv", "", "CSV file to append the benchmark result to, with a header row if the file is new")
+	outputPtr := flag.String("out", "", "File to save the benchmark result JSON to, e.g. as a baseline for \"ollamark regress\"")
+	uploadS3Ptr := flag.Bool("upload-s3", false, "Upload benchmark results to the S3-compatible bucket configured by OLLAMARK_S3_ENDPOINT and OLLAMARK_S3_BUCKET")
+	promptSetPtr := flag.String("prompt-set", "", "File with one prompt per line, generated once each with a fixed number of tokens instead of the default prompt")
+	debugResponsesPtr := flag.String("debug-responses", "", "File to write the raw /api/generate responses to, for debugging")
+	warmupPromptPtr := flag.String("warmup-prompt", defaultWarmupPrompt, "Prompt for the unmeasured warmup generation that loads the model, empty to skip warmup")
+	warmupPtr := flag.Int("warmup", 0, "Runs of the benchmark prompt before the measured iterations whose results are discarded")
+	gpuPtr := flag.String("gpu", "", "Name or vendor of the GPU used for inference if several are detected, e.g. \"nvidia\", default the first detected")
+	assetsDirPtr := flag.String("assets-dir", defaultAssetsDir(), "GUI only: directory containing logo.svg and loader.gif")
+	promptPtr := flag.String("p", "", "Prompt to benchmark with instead of the default prompt")
+	promptFilePtr := flag.String("pf", "", "File containing the prompt to benchmark with, takes precedence over -p")
+	jsonPtr := flag.Bool("json", false, "Print the benchmark result as JSON to stdout, all other output goes to stderr")
+	quietPtr := flag.Bool("q", false, "Quiet: print only the average tokens per second, or only the JSON with -json. Errors still go to stderr")
+	apiPtr := flag.String("api", "", "Ollamark API endpoint for the model list and submissions, overrides OLLAMARK_API")
+	configURLPtr := flag.String("config-url", "", "URL of a fleet config with the model list, prompt and benchmark profiles, cached locally in case it can't be reached")
+	profilePtr := flag.String("profile", "", "Benchmark profile of the -config-url config to apply, flags given on the command line win")
 	flag.Parse()
 
+	// Flags given on the command line, before a profile sets any
+	explicitFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = true
+	})
+
+	ollamarkAPIOverride = *apiPtr
+	if !modelsLoaded {
+		initOllamarkModels()
+	}
+
+	if *configURLPtr != "" {
+		config, err := loadFleetConfig(*configURLPtr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		if len(config.Models) > 0 {
+			globalModels = config.Models
+		}
+		if config.Prompt != "" {
+			benchmarkPrompt = config.Prompt
+		}
+		if *profilePtr != "" {
+			if err := applyProfile(config, *profilePtr, explicitFlags); err != nil {
+				usageError(err.Error())
+			}
+		}
+	} else if *profilePtr != "" {
+		usageError("-profile requires -config-url")
+	}
+
 	// Set the global API endpoint
 	apiEndpoint = *ollamaPtr
+	ollamaClient = newOllamaClient(*connectTimeoutPtr)
+	useLocalModels(apiEndpoint)
+	requestTimeout = *requestTimeoutPtr
+	if explicitFlags["timeout"] {
+		if explicitFlags["request-timeout"] {
+			usageError("-timeout and -request-timeout can't be combined")
+		}
+		if *timeoutPtr < 0 {
+			usageError(fmt.Sprintf("timeout must not be negative, got %d", *timeoutPtr))
+		}
+		requestTimeout = time.Duration(*timeoutPtr) * time.Second
+	}
+	gpuSelector = *gpuPtr
 
-	// Check if CLI arguments are provided
-	if flag.NFlag() > 0 {
+	// Check if CLI arguments are provided, -assets-dir, -config-url and -api alone still start the GUI
+	cliFlags := 0
+	for name := range explicitFlags {
+		if name != "assets-dir" && name != "config-url" && name != "api" {
+			cliFlags++
+		}
+	}
+	if cliFlags > 0 {
 
-		if *modelPtr == "" || *ollamaPtr == "" {
-			flag.Usage()
-			os.Exit(1)
+		if *modelPtr == "" {
+			usageError("model name must not be empty")
+		}
+		models, err := parseModelList(*modelPtr)
+		if err != nil {
+			usageError(err.Error())
+		}
+		if len(models) > 1 {
+			if *digestPtr != "" || *outputPtr != "" || *jsonPtr {
+				usageError("-digest, -out and -json apply to a single model, they can't be combined with several -m models")
+			}
+			if *threadsSweepPtr != "" || *modelsFromTagsPtr {
+				usageError("several -m models can't be combined with -threads-sweep or -models-from-tags")
+			}
+		}
+
+		if *ollamaPtr == "" {
+			usageError("Ollama API endpoint must not be empty")
 		}
 
 		if flag.NArg() > 0 {
-			flag.Usage()
-			os.Exit(1)
+			usageError(fmt.Sprintf("unexpected arguments: %s", strings.Join(flag.Args(), " ")))
 		}
 
 		if (*iterationsPtr < 2) || (*iterationsPtr > 20) {
-			flag.Usage()
-			os.Exit(1)
+			usageError(fmt.Sprintf("iterations must be between 2 and 20, got %d", *iterationsPtr))
 		}
 
-		// Run ollamark in CLI mode
-		runBenchmarkCLI(*modelPtr, *submitPtr, apiEndpoint, *iterationsPtr)
-		return
-	}
+		if *precisionPtr < 0 || *precisionPtr > 10 {
+			usageError(fmt.Sprintf("precision must be between 0 and 10, got %d", *precisionPtr))
+		}
+		tpsPrecision = *precisionPtr
 
-	// Create a new Fyne app
-	a := app.NewWithID("Ollamark")
-	a.Settings().SetTheme(theme.DarkTheme())
-	fyne.CurrentApp().Settings().SetTheme(fyne.CurrentApp().Settings().Theme())
-	w := a.NewWindow("Ollamark - Ollama Benchmark")
+		if *threadsPtr < 0 {
+			usageError(fmt.Sprintf("threads must not be negative, got %d", *threadsPtr))
+		}
+
+		var threadsSweep []int
+		if *threadsSweepPtr != "" {
+			var err error
+			if threadsSweep, err = parseThreadList(*threadsSweepPtr); err != nil {
+				usageError(err.Error())
+			}
+			if *threadsPtr > 0 {
+				usageError("-threads and -threads-sweep can't be combined")
+			}
+			if *submitPtr || *outputPtr != "" || *uploadS3Ptr || *savePtr || *csvPtr != "" {
+				usageError("-threads-sweep only reports the results, it can't be combined with -s, -out, -save, -csv or -upload-s3")
+			}
+		}
+
+		if *modelsFilterPtr != "" && !*modelsFromTagsPtr {
+			usageError("-models-filter requires -models-from-tags")
+		}
+
+		if *modelsFromTagsPtr {
+			if _, err := filepath.Match(*modelsFilterPtr, ""); err != nil {
+				usageError(fmt.Sprintf("invalid -models-filter: %v", err))
+			}
+			if threadsSweep != nil {
+				usageError("-models-from-tags and -threads-sweep can't be combined")
+			}
+			if *submitPtr || *outputPtr != "" || *uploadS3Ptr || *savePtr || *csvPtr != "" {
+				usageError("-models-from-tags only reports the results, it can't be combined with -s, -out, -save, -csv or -upload-s3")
+			}
+		}
+
+		if *jsonPtr {
+			if threadsSweep != nil || *modelsFromTagsPtr {
+				usageError("-json can't be combined with -threads-sweep or -models-from-tags")
+			}
+			messages = os.Stderr
+			errorMessages = os.Stderr
+		}
+
+		if *quietPtr {
+			if len(models) > 1 || threadsSweep != nil || *modelsFromTagsPtr {
+				usageError("-q applies to a single model, it can't be combined with several -m models, -threads-sweep or -models-from-tags")
+			}
+			messages = io.Discard
+			errorMessages = os.Stderr
+		}
+
+		prompt := *promptPtr
+		if *promptFilePtr != "" {
+			data, err := os.ReadFile(*promptFilePtr)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error: reading the prompt file:", err)
+				os.Exit(1)
+			}
+			if prompt = strings.TrimSpace(string(data)); prompt == "" {
+				usageError(fmt.Sprintf("prompt file %s is empty", *promptFilePtr))
+			}
+		} else if explicitFlags["p"] && prompt == "" {
+			usageError("prompt must not be empty")
+		}
+		if prompt != "" && *promptSetPtr != "" {
+			usageError("-p and -pf can't be combined with -prompt-set")
+		}
+
+		if *numPredictPtr < 0 {
+			usageError(fmt.Sprintf("num predict must not be negative, got %d", *numPredictPtr))
+		}
+		if *numPredictPtr > 0 && *promptSetPtr != "" {
+			usageError(fmt.Sprintf("-n can't be combined with -prompt-set, which generates %d tokens per prompt", promptSetNumPredict))
+		}
+
+		// Seed and temperature 0 are meaningful, so only the flags that were set are sent
+		var seed *int
+		if isFlagSet("seed") {
+			seed = seedPtr
+		}
+		var temperature *float64
+		if isFlagSet("temp") {
+			if *tempPtr < 0 {
+				usageError(fmt.Sprintf("temperature must not be negative, got %g", *tempPtr))
+			}
+			temperature = tempPtr
+		}
+		if *ctxPtr < 0 {
+			usageError(fmt.Sprintf("context size must not be negative, got %d", *ctxPtr))
+		}
+
+		if *warmupPtr < 0 {
+			usageError(fmt.Sprintf("warmup runs must not be negative, got %d", *warmupPtr))
+		}
+
+		if *minTokensPtr < 0 {
+			usageError(fmt.Sprintf("min tokens must not be negative, got %d", *minTokensPtr))
+		}
+
+		if *totalTokensPtr < 0 {
+			usageError(fmt.Sprintf("total tokens must not be negative, got %d", *totalTokensPtr))
+		}
+
+		if *totalTokensPtr > 0 && *promptSetPtr != "" {
+			usageError("-total-tokens and -prompt-set can't be combined")
+		}
+
+		if *durationPtr < 0 {
+			usageError(fmt.Sprintf("duration must not be negative, got %s", *durationPtr))
+		}
+
+		if *durationPtr > 0 && (*totalTokensPtr > 0 || *promptSetPtr != "") {
+			usageError("-duration can't be combined with -total-tokens or -prompt-set")
+		}
+
+		if *debugResponsesPtr != "" {
+			debugFile, err := os.Create(*debugResponsesPtr)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(1)
+			}
+			defer debugFile.Close()
+			debugResponses = debugFile
+		}
+
+		// Stop the benchmark on Ctrl-C, keeping the iterations completed so far
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		opts := BenchmarkOptions{
+			ModelName:     *modelPtr,
+			Submit:        *submitPtr,
+			OllamaAPI:     apiEndpoint,
+			Iterations:    *iterationsPtr,
+			Digest:        *digestPtr,
+			CompareStream: *compareStreamPtr,
+			WarmupPrompt:  *warmupPromptPtr,
+			Warmup:        *warmupPtr,
+			PromptSet:     *promptSetPtr,
+			UploadS3:      *uploadS3Ptr,
+			Output:        *outputPtr,
+			Save:          *savePtr,
+			CSV:           *csvPtr,
+			TotalTokens:   *totalTokensPtr,
+			Duration:      *durationPtr,
+			Tags:          parseTags(*tagsPtr),
+			Threads:       *threadsPtr,
+			NumPredict:    *numPredictPtr,
+			Seed:          seed,
+			Temperature:   temperature,
+			NumCtx:        *ctxPtr,
+			Chat:          *chatPtr,
+			FormatJSON:    *formatJSONPtr,
+			MinTokens:     *minTokensPtr,
+			Force:         *forcePtr,
+			NoPull:        !*pullPtr,
+			ConfigURL:     *configURLPtr,
+			JSON:          *jsonPtr,
+			Quiet:         *quietPtr,
+			Prompt:        prompt,
+		}
+
+		if threadsSweep != nil {
+			runThreadsSweep(ctx, opts, threadsSweep)
+			return
+		}
+
+		if *modelsFromTagsPtr {
+			runLocalModels(ctx, opts, *modelsFilterPtr)
+			return
+		}
+
+		if len(models) > 1 {
+			runModels(ctx, opts, models)
+			return
+		}
+
+		// Run ollamark in CLI mode
+		if _, err := runBenchmarkCLI(ctx, opts); err != nil {
+			fmt.Fprintln(errorMessages, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Create a new Fyne app
+	a := app.NewWithID("Ollamark")
+	setTheme(a, a.Preferences().BoolWithFallback(prefDarkTheme, true))
+	w := a.NewWindow("Ollamark - Ollama Benchmark")
 
 	// set window size
 	w.Resize(fyne.NewSize(400, 300))
 	w.CenterOnScreen()
 
 	// create a logo
-	logo := canvas.NewImageFromFile("logo.svg")
+	logo := canvas.NewImageFromFile(filepath.Join(*assetsDirPtr, "logo.svg"))
 	logo.FillMode = canvas.ImageFillContain // Use 'Contain' to ensure the image fits well
 	logo.SetMinSize(fyne.NewSize(100, 100))
 
 	// Load the SVG icon
-	icon, err := fyne.LoadResourceFromPath("logo.svg")
+	icon, err := fyne.LoadResourceFromPath(filepath.Join(*assetsDirPtr, "logo.svg"))
 	if err != nil {
 		// Handle the error if the icon file cannot be loaded
 		fmt.Println("Failed to load icon:", err)
@@ -668,12 +2243,20 @@ func main() {
 	}
 
 	sysinfo, _ := getSysInfo()
+	gpus, _ := getAllGPUInfo()
 	gpuinfo, _ := getGPUInfo()
 	ollamaVersion = getOllamaVersion()
 
+	// The last used endpoint, model, iterations and theme are restored from the preferences
+	prefs := a.Preferences()
+
 	// create an api entry field
 	apiEntry := widget.NewEntry()
-	apiEntry.SetText(apiEndpoint)
+	apiEntry.SetText(prefs.StringWithFallback(prefLastEndpoint, apiEndpoint))
+	apiEntry.Validator = func(text string) error {
+		_, err := normalizeEndpoint(text)
+		return err
+	}
 
 	// create a title label
 	titleLabel := widget.NewLabel("Ollama API Endpoint")
@@ -693,34 +2276,50 @@ func main() {
 		// You can add logic here if needed when a model is selected
 	})
 
-	// Set the default selected model
-	// Find the index of "llama3" in the modelNames slice
+	// Select the last used model if it is still supported, otherwise llama3
+	lastModel := prefs.String(prefLastModel)
 	defaultIndex := 0
 	for i, name := range modelNames {
-		if name == "llama3" {
+		if name == lastModel {
 			defaultIndex = i
 			break
 		}
+		if name == "llama3" {
+			defaultIndex = i
+		}
+	}
+	if len(modelNames) > 0 {
+		modelSelect.SetSelected(modelNames[defaultIndex])
 	}
-	modelSelect.SetSelected(modelNames[defaultIndex])
 
 	resultLabel := widget.NewLabel("")
 	resultLabel.Alignment = fyne.TextAlignCenter
 	resultLabel.Hide()
 
 	// Custom text field for tokens per second
-	tokensPerSecondText := canvas.NewText("", color.White)
+	tokensPerSecondText := canvas.NewText("", theme.ForegroundColor())
 	tokensPerSecondText.TextStyle.Bold = true
 	tokensPerSecondText.TextSize = 38 // Larger text size
 	tokensPerSecondText.Alignment = fyne.TextAlignCenter
 	tokensPerSecondText.Hide()
 
-	tpsText := canvas.NewText("", color.White)
+	tpsText := canvas.NewText("", theme.ForegroundColor())
 	tpsText.TextStyle.Bold = true
 	tpsText.TextSize = 16 // Larger text size
 	tpsText.Alignment = fyne.TextAlignCenter
 	tpsText.Hide()
 
+	// The large texts don't follow the theme by themselves, so recolor them on a switch
+	darkThemeCheck := widget.NewCheck("Dark theme", func(dark bool) {
+		setTheme(a, dark)
+		prefs.SetBool(prefDarkTheme, dark)
+		tokensPerSecondText.Color = theme.ForegroundColor()
+		tpsText.Color = theme.ForegroundColor()
+		tokensPerSecondText.Refresh()
+		tpsText.Refresh()
+	})
+	darkThemeCheck.SetChecked(prefs.BoolWithFallback(prefDarkTheme, true))
+
 	sysText := widget.NewLabel("")
 	sysText.Hide()
 
@@ -731,14 +2330,19 @@ func main() {
 	ollamaVersionText.Hide()
 
 	iterationsSlider := widget.NewSlider(2, 20)
-	iterationsSlider.SetValue(2)
 	iterationsSlider.Step = 1
+	iterationsSlider.SetValue(float64(prefs.IntWithFallback(prefLastIterations, 2)))
 
-	iterationsLabel := widget.NewLabel("Iterations: 2")
+	iterationsLabel := widget.NewLabel(fmt.Sprintf("Iterations: %d", int(iterationsSlider.Value)))
 	iterationsSlider.OnChanged = func(value float64) {
 		iterationsLabel.SetText(fmt.Sprintf("Iterations: %d", int(value)))
 	}
 
+	// An optional token budget makes runs comparable and the progress bar determinate
+	numPredictLabel := widget.NewLabel("Tokens per iteration")
+	numPredictEntry := widget.NewEntry()
+	numPredictEntry.SetPlaceHolder("Let the model decide")
+
 	sysText.SetText(fmt.Sprintf("CPU: %s\nMemory: %s\nOS: %s\nKernel: %s", sysinfo.CPUName, sysinfo.Memory, sysinfo.OS, sysinfo.Kernel))
 	sysText.Show()
 	sysText.Refresh()
@@ -755,11 +2359,13 @@ func main() {
 	ollamaVersionText.Show()
 	ollamaVersionText.Refresh()
 
-	// create a progress bar
+	// create a progress bar, the token bar replaces it while generating with a token budget
 	progressBar := widget.NewProgressBarInfinite()
 	progressBar.Hide()
+	tokenProgressBar := widget.NewProgressBar()
+	tokenProgressBar.Hide()
 
-	gifURI := storage.NewFileURI("loader.gif")
+	gifURI := storage.NewFileURI(filepath.Join(*assetsDirPtr, "loader.gif"))
 	gif, err := xwidget.NewAnimatedGif(gifURI)
 	if err != nil {
 		fmt.Println("Error loading gif:", err)
@@ -772,6 +2378,15 @@ func main() {
 	var submitButton *widget.Button
 	var linkButton *widget.Button
 
+	// The cancel button stops the running benchmark through its context
+	var cancelRun context.CancelFunc
+	cancelButton := widget.NewButton("Cancel", func() {
+		if cancelRun != nil {
+			cancelRun()
+		}
+	})
+	cancelButton.Hide()
+
 	benchmarkButton := widget.NewButton("Benchmark", nil)
 	benchmarkButton.OnTapped = func() {
 		linkButton.Hide()
@@ -779,6 +2394,10 @@ func main() {
 		benchmarkButton.Disable()
 		submitButton.Disable()
 
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelRun = cancel
+		cancelButton.Show()
+
 		resultLabel.Show()
 		resultLabel.SetText("Benchmarks starting...")
 		resultLabel.Refresh()
@@ -789,132 +2408,260 @@ func main() {
 		// gpuText.Hide()
 
 		go func() {
+			defer cancel()
+			defer cancelButton.Hide()
+
+			// resetRun ends a failed or cancelled run, showing message and restoring the controls
+			resetRun := func(message string) {
+				resultLabel.SetText(message)
+				benchmarkButton.SetText("Benchmark")
+				benchmarkButton.Enable()
+				progressBar.Hide()
+				tokenProgressBar.Hide()
+				progressBar.Refresh()
+				gif.Hide()
+			}
+
+			// cancelled resets the UI if the run was cancelled, the errors it causes aren't shown
+			cancelled := func() bool {
+				if ctx.Err() == nil {
+					return false
+				}
+				resetRun("Benchmark cancelled.")
+				return true
+			}
+
+			setupStart := time.Now()
 			progressBar.Show()
 			progressBar.Refresh()
 
-			// get api url and model name from entry fields
-			apiURL := apiEntry.Text
+			// Every Ollama request of the run, the pull included, goes to the entered base URL
+			baseURL, err := normalizeEndpoint(apiEntry.Text)
+			if err != nil {
+				resetRun("Error: " + err.Error())
+				return
+			}
+			apiEntry.SetText(baseURL)
 			modelName := modelSelect.Selected
 			iterations := int(iterationsSlider.Value)
+			prefs.SetString(prefLastEndpoint, baseURL)
+			prefs.SetString(prefLastModel, modelName)
+			prefs.SetInt(prefLastIterations, iterations)
+			numPredict := 0
+			if text := strings.TrimSpace(numPredictEntry.Text); text != "" {
+				n, err := strconv.Atoi(text)
+				if err != nil || n < 0 {
+					resetRun(fmt.Sprintf("Tokens per iteration must be a non-negative number, got %q", text))
+					return
+				}
+				numPredict = n
+			}
 
-			modelRequest := ModelRequest{
-				Name: modelName,
+			if err := pingOllama(baseURL); err != nil {
+				resetRun(err.Error())
+				return
 			}
-			jsonData, _ := json.Marshal(modelRequest)
-			fullURL := apiEndpoint + "/api/pull"
-			resultLabel.SetText("Pulling model " + modelName + ", Please wait...")
-			resultLabel.Refresh()
-			resp, err := http.Post(fullURL, "application/json", bytes.NewBuffer(jsonData))
+
+			// An installed model doesn't need the network round trip of a pull
+			if modelInstalled(baseURL, modelName) {
+				resultLabel.SetText("Model " + modelName + " is already installed")
+				resultLabel.Refresh()
+			} else {
+				modelRequest := ModelRequest{
+					Name: modelName,
+				}
+				jsonData, _ := json.Marshal(modelRequest)
+				resultLabel.SetText("Pulling model " + modelName + ", Please wait...")
+				resultLabel.Refresh()
+				resp, err := ollamaPostContext(ctx, baseURL+"/api/pull", jsonData)
+				if cancelled() {
+					return
+				}
+				if err != nil {
+					resetRun("Error: " + err.Error())
+					return
+				}
+				defer resp.Body.Close()
+
+				body, _ := io.ReadAll(resp.Body)
+				if resp.StatusCode != http.StatusOK {
+					resetRun(fmt.Sprintf("Error pulling model: %s", body))
+					return
+				}
+
+				// fmt.Println("Model pull response:", string(body)) // Debug print
+				resultLabel.SetText("Model pulled successfully")
+				resultLabel.Refresh()
+			}
+
+			modelDigest, err := getModelDigest(baseURL, modelName)
 			if err != nil {
-				resultLabel.SetText("Error: " + err.Error())
-				benchmarkButton.SetText("Benchmark")
-				benchmarkButton.Enable()
-				progressBar.Hide()
-				progressBar.Refresh()
-				gif.Hide()
+				resetRun("Error: " + err.Error())
 				return
 			}
-			defer resp.Body.Close()
 
-			body, _ := io.ReadAll(resp.Body)
-			if resp.StatusCode != http.StatusOK {
-				resultLabel.SetText(fmt.Sprintf("Error pulling model: %s", body))
-				benchmarkButton.SetText("Benchmark")
-				benchmarkButton.Enable()
-				progressBar.Hide()
-				progressBar.Refresh()
-				gif.Hide()
-				return
+			// Model details are informational, older Ollama versions may not provide them
+			modelDetails, err := showModel(baseURL, modelName)
+			if err != nil {
+				fmt.Println("Failed to get model details:", err)
 			}
 
-			// fmt.Println("Model pull response:", string(body)) // Debug print
-			resultLabel.SetText("Model pulled successfully")
+			resultLabel.SetText("Warming up model...")
 			resultLabel.Refresh()
+
+			// Load the model with a cheap generation so loading time isn't measured
+			_, _, err = generate(ctx, baseURL, OllamaRequest{ModelName: modelName, Prompt: defaultWarmupPrompt})
+			if cancelled() {
+				return
+			}
+			if err != nil {
+				resetRun("Error: " + err.Error())
+				return
+			}
+
 			resultLabel.SetText("Benchmarking...")
 			resultLabel.Refresh()
 
 			var totalTokensPerSecond float64
 			var evalCount int
 			var evalDuration float64
+			var degraded bool
+			var suspicious bool
+			var iterationTPS []float64
+			var timings ollamaTimings
+
+			// Show real progress when the total number of tokens is known
+			var tokensGenerated int
+			if numPredict > 0 {
+				progressBar.Hide()
+				tokenProgressBar.SetValue(0)
+				tokenProgressBar.Show()
+			}
 
 			start := time.Now()
+			sampler := startGPUSampler(time.Second)
+			defer sampler.stop()
 
 			for i := 0; i < iterations; i++ {
 				requestBody := OllamaRequest{
 					ModelName: modelName,
-					Prompt:    "Tell me about Llamas in 500 words.",
+					Prompt:    benchmarkPrompt,
+				}
+				if numPredict > 0 {
+					requestBody.Options = map[string]interface{}{"num_predict": numPredict}
 				}
 
 				jsonData, _ := json.Marshal(requestBody)
-				resp, err := http.Post(apiURL+"/api/generate", "application/json", bytes.NewBuffer(jsonData))
+				resp, err := ollamaPostContext(ctx, baseURL+"/api/generate", jsonData)
+				if cancelled() {
+					return
+				}
 				if err != nil {
-					resultLabel.SetText("Error: " + err.Error())
-					benchmarkButton.SetText("Benchmark")
-					benchmarkButton.Enable()
-					progressBar.Hide()
-					progressBar.Refresh()
-					gif.Hide()
+					resetRun("Error: " + err.Error())
 					return
 				}
 				defer resp.Body.Close()
 
 				// start := time.Now()
 
-				var response OllamaResponse
-				var responseText string
-				decoder := json.NewDecoder(resp.Body)
-
 				resultLabel.SetText(fmt.Sprintf("Benchmark #%d in progress...", i+1))
 				resultLabel.Refresh()
 
-				for {
-					err := decoder.Decode(&response)
-					if err == io.EOF {
-						break
-					}
-					if err != nil {
-						resultLabel.SetText("Error: " + err.Error())
-						progressBar.Hide()
+				response, text, err := decodeGenerateStream(resp.Body, func(chunks int, _ time.Duration) {
+					if numPredict > 0 {
+						tokenProgressBar.SetValue(math.Min(float64(tokensGenerated+chunks)/float64(numPredict*iterations), 1))
+					} else {
 						progressBar.Refresh()
-						benchmarkButton.SetText("Benchmark")
-						benchmarkButton.Enable()
-						return
 					}
-
-					responseText += response.Response
-					progressBar.Refresh()
+				})
+				// A cancelled stream can still decode as a partial response
+				if cancelled() {
+					return
+				}
+				if err != nil {
+					resetRun("Error: " + err.Error())
+					return
+				}
+				if response.Partial {
+					degraded = true
+				}
+				if !tokenCountConsistent(response.EvalCount, text) {
+					suspicious = true
 				}
 
 				// duration := time.Since(start).Seconds()
 				tokensPerSecond := float64(response.EvalCount) / (float64(response.EvalDuration) / 1e9)
 
 				totalTokensPerSecond += tokensPerSecond
+				iterationTPS = append(iterationTPS, tokensPerSecond)
+				timings.add(response)
+				tokensGenerated += response.EvalCount
 				evalCount = response.EvalCount
 				evalDuration = float64(response.EvalDuration) / 1e9
 			}
+			measuredDuration := time.Since(start).Seconds()
+
+			if gpuinfo != nil {
+				gpuinfo.Usage = sampler.stop()
+			}
 
 			EvalCount := evalCount
 			EvalDuration := evalDuration
 
 			avgTokensPerSecond := totalTokensPerSecond / float64(iterations)
+			minTPS, maxTPS, stdDev := tpsSpread(iterationTPS)
 
 			benchmarkResult = &BenchmarkResult{
-				ModelName:       modelName,
-				Timestamp:       time.Now().Unix(),
-				Duration:        time.Since(start).Seconds(),
-				EvalCount:       EvalCount,
-				EvalDuration:    int64(EvalDuration),
-				TokensPerSecond: avgTokensPerSecond,
-				Iterations:      iterations,
-				SysInfo:         sysinfo,
-				GPUInfo:         gpuinfo,
-				OllamaVersion:   ollamaVersion,
-				ClientType:      "ollamark-gui",
-				ClientVersion:   clientVersion,
-				IP:              getIPAddress(),
-			}
-
-			resultLabel.SetText(fmt.Sprintf("Benchmark completed for %s\nAverage Tokens per second: %.2f\nBenchmarked with %d iterations", modelName, avgTokensPerSecond, iterations))
+				ModelName:           modelName,
+				ModelDigest:         modelDigest,
+				ModelDetails:        modelDetails,
+				Prompt:              benchmarkPrompt,
+				PromptHash:          promptHash(benchmarkPrompt),
+				WarmupPrompt:        defaultWarmupPrompt,
+				WarmupPromptHash:    promptHash(defaultWarmupPrompt),
+				Timestamp:           time.Now().Unix(),
+				Duration:            measuredDuration,
+				TotalDurationSec:    measuredDuration,
+				SetupDurationSec:    start.Sub(setupStart).Seconds(),
+				EvalCount:           EvalCount,
+				EvalDuration:        int64(EvalDuration),
+				TokensPerSecond:     avgTokensPerSecond,
+				MinTPS:              minTPS,
+				MaxTPS:              maxTPS,
+				StdDev:              stdDev,
+				Iterations:          iterations,
+				NumPredict:          numPredict,
+				SysInfo:             sysinfo,
+				GPUInfo:             gpuinfo,
+				GPUs:                gpus,
+				OllamaVersion:       ollamaVersion,
+				ClientType:          "ollamark-gui",
+				ClientVersion:       clientVersion,
+				IP:                  getIPAddress(),
+				MachineID:           machineFingerprint(sysinfo, gpuinfo),
+				Environment:         detectEnvironment(),
+				Degraded:            degraded,
+				Suspicious:          suspicious,
+				CompletedIterations: iterations,
+			}
+			timings.apply(benchmarkResult)
+
+			// Keep the result for the history chart
+			if err := appendHistory(historyPath(), benchmarkResult); err != nil {
+				fmt.Println("Failed to add the result to the history:", err)
+			}
+
+			resultText := fmt.Sprintf("Benchmark completed for %s\nAverage Tokens per second: %.2f\nBenchmarked with %d iterations", modelName, avgTokensPerSecond, iterations)
+			if degraded {
+				resultText += "\nWarning: a response stream broke off, the result is partly estimated"
+			}
+			if suspicious {
+				resultText += "\nWarning: the reported token count doesn't match the generated text"
+			}
+			if warning := checkGPUOffload(baseURL, modelName, gpuinfo); warning != "" {
+				resultText += "\nWarning: " + warning
+			}
+			resultLabel.SetText(resultText)
 			resultLabel.Alignment = fyne.TextAlignCenter
 			resultLabel.Refresh()
 
@@ -927,6 +2674,7 @@ func main() {
 			tpsText.Show()
 
 			progressBar.Hide()
+			tokenProgressBar.Hide()
 			gif.Hide()
 			progressBar.Refresh() // Refresh after hiding the ProgressBar
 			benchmarkButton.SetText("Benchmark")
@@ -942,7 +2690,15 @@ func main() {
 
 	submitButton.OnTapped = func() {
 		if benchmarkResult != nil {
-			subEndpoint := os.Getenv("OLLAMARK_API")
+			if modelListOffline {
+				resultLabel.SetText("Error: " + errModelListOffline.Error())
+				return
+			}
+			if err := checkSubmitEnv(); err != nil {
+				resultLabel.SetText("Error: " + err.Error())
+				return
+			}
+			subEndpoint := ollamarkAPI()
 			secretKey := os.Getenv("KEY")
 			publicKey, err := LoadPublicKey()
 			if err != nil {
@@ -959,13 +2715,6 @@ func main() {
 
 			var submissionID = generateUUID()
 
-			// Generate JWT token
-			jwtToken, err := generateJWT(submissionID)
-			if err != nil {
-				resultLabel.SetText("Error generating JWT token: " + err.Error())
-				return
-			}
-
 			// Request proof-of-work challenge
 			challenge, err := requestProofOfWorkChallenge(subEndpoint)
 			if err != nil {
@@ -974,18 +2723,29 @@ func main() {
 			}
 
 			// Solve proof-of-work challenge
-			powNonce, err := solveProofOfWork(challenge)
+			resultLabel.SetText(fmt.Sprintf("Solving proof-of-work at difficulty %d, about %s...", challenge.Difficulty, estimateProofOfWorkTime(challenge.Difficulty).Round(time.Second)))
+			powNonce, err := solveProofOfWork(challenge, func(attempts int, elapsed time.Duration) {
+				resultLabel.SetText(powProgressText(attempts, elapsed))
+			})
 			if err != nil {
 				resultLabel.SetText("Error solving proof-of-work challenge: " + err.Error())
 				return
 			}
 
+			// Generate JWT token once solved, so a long solve doesn't use up its lifetime
+			jwtToken, err := generateJWT(submissionID, jwtExpiry(challenge))
+			if err != nil {
+				resultLabel.SetText("Error generating JWT token: " + err.Error())
+				return
+			}
+
 			// Include proof-of-work solution in the benchmark result
 			benchmarkResult.ProofOfWork = ProofOfWorkSolution{
 				Challenge:  challenge.Challenge,
 				Nonce:      powNonce,
 				Timestamp:  challenge.Timestamp,
 				Difficulty: challenge.Difficulty,
+				Signature:  challenge.Signature,
 			}
 
 			// Encrypt benchmark result with AES key
@@ -1025,6 +2785,7 @@ func main() {
 			req.Header.Set("Authorization", "Bearer "+jwtToken)
 			req.Header.Set("X-Submission-ID", submissionID)
 			req.Header.Set("X-Signature", signature)
+			req.Header.Set("User-Agent", userAgent())
 
 			client := &http.Client{}
 			resp, err := client.Do(req)
@@ -1035,8 +2796,7 @@ func main() {
 			defer resp.Body.Close()
 
 			if resp.StatusCode != http.StatusOK {
-				body, _ := io.ReadAll(resp.Body)
-				resultLabel.SetText("Error submitting benchmark: " + string(body))
+				resultLabel.SetText("Error submitting benchmark: " + parseAPIError(resp).Error())
 				return
 			}
 
@@ -1058,6 +2818,24 @@ func main() {
 	submitButton.Hide()
 	linkButton.Hide()
 
+	historyButton := widget.NewButton("History", func() {
+		showHistoryWindow(a)
+	})
+
+	// Ctrl+Enter (Cmd+Enter on macOS) and Enter in the endpoint field start a benchmark,
+	// unless one is already running
+	startBenchmark := func() {
+		if !benchmarkButton.Disabled() {
+			benchmarkButton.OnTapped()
+		}
+	}
+	w.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyReturn, Modifier: fyne.KeyModifierShortcutDefault}, func(fyne.Shortcut) {
+		startBenchmark()
+	})
+	apiEntry.OnSubmitted = func(string) {
+		startBenchmark()
+	}
+
 	// border/group around systext and gputext
 	sysInfoGroup := container.NewVBox(ollamaVersionText, sysText, gpuText)
 	sysInfoGroupLabel := widget.NewLabel("System Information")
@@ -1066,6 +2844,7 @@ func main() {
 
 	content := container.NewVBox(
 		logo,
+		darkThemeCheck,
 		sysInfoGroup,
 		titleLabel,
 		apiEntry,
@@ -1073,16 +2852,21 @@ func main() {
 		modelSelect,
 		iterationsLabel,
 		iterationsSlider,
+		numPredictLabel,
+		numPredictEntry,
 		gif,
 		// widget.NewSeparator(),
 		tokensPerSecondText,
 		tpsText,
 		resultLabel,
 		progressBar,
+		tokenProgressBar,
 		// widget.NewSeparator(),
 		benchmarkButton,
+		cancelButton,
 		submitButton,
 		linkButton,
+		historyButton,
 	)
 
 	// Wrap the content with a padded container
@@ -1092,167 +2876,2013 @@ func main() {
 	w.ShowAndRun()
 }
 
-func contains(models []ModelInfo, modelName string) bool {
-	for _, model := range models {
-		if model.Name == modelName {
-			return true
-		}
-	}
-	return false
-}
+// Fyne preference keys for the GUI settings that persist across sessions
+const (
+	prefLastEndpoint   = "lastEndpoint"
+	prefLastModel      = "lastModel"
+	prefLastIterations = "lastIterations"
+	prefDarkTheme      = "darkTheme"
+)
 
-func runBenchmarkCLI(modelName string, submit bool, ollamaAPI string, iterations int) {
-	ollamaAPIURL := ollamaAPI
+// historyChartSize is the size of the chart in the GUI history window
+var historyChartSize = fyne.NewSize(600, 340)
+
+// historyChartColors are the line colors of the models in the history chart
+var historyChartColors = []color.Color{
+	color.NRGBA{R: 0x4f, G: 0x7c, B: 0xff, A: 0xff},
+	color.NRGBA{R: 0xff, G: 0x8a, B: 0x3d, A: 0xff},
+	color.NRGBA{R: 0x2e, G: 0xb8, B: 0x72, A: 0xff},
+	color.NRGBA{R: 0xe0, G: 0x4f, B: 0x5f, A: 0xff},
+	color.NRGBA{R: 0x9b, G: 0x6b, B: 0xdf, A: 0xff},
+	color.NRGBA{R: 0xd4, G: 0xb1, B: 0x2f, A: 0xff},
+}
 
-	var totalTokensPerSecond float64
-	var evalCount int
-	var evalDuration float64
+// showHistoryWindow opens a window with a line chart of the tokens per second of the
+// results in the local history, for all models or the one selected
+func showHistoryWindow(a fyne.App) {
+	const allModels = "All models"
 
-	// modelName needs to match a model name in MODELS
-	if !contains(globalModels, modelName) {
-		fmt.Println("Model not supported. Please use a supported model from the list:", globalModels)
+	w := a.NewWindow("Ollamark - History")
+	history, skipped, err := loadHistory(historyPath())
+	if err != nil && !os.IsNotExist(err) {
+		w.SetContent(widget.NewLabel("Error: " + err.Error()))
+		w.Show()
 		return
 	}
-
-	sysinfo, err := getSysInfo()
-	if err != nil {
-		// fmt.Println("Error:", err)
-		return
+	if skipped > 0 {
+		fmt.Printf("Skipped %d corrupt lines in %s\n", skipped, historyPath())
 	}
-	fmt.Printf("CPU: %+v\n", sysinfo.CPUName)
-	fmt.Printf("Memory: %+v\n", sysinfo.Memory)
-	fmt.Printf("OS: %+v\n", sysinfo.OS)
-	fmt.Printf("Kernel: %+v\n", sysinfo.Kernel)
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Timestamp < history[j].Timestamp
+	})
 
-	gpuinfo, err := getGPUInfo()
-	if err != nil {
-		// fmt.Println("Error:", err)
-		return
+	models := []string{allModels}
+	seen := make(map[string]bool)
+	for _, benchmarkResult := range history {
+		if !seen[benchmarkResult.ModelName] {
+			seen[benchmarkResult.ModelName] = true
+			models = append(models, benchmarkResult.ModelName)
+		}
 	}
-	fmt.Printf("GPU Name: %+v\n", gpuinfo.Name)
-	fmt.Printf("Driver Version: %+v\n", gpuinfo.DriverVersion)
-	fmt.Printf("GPU Memory: %+v\n", gpuinfo.Memory)
 
-	modelRequest := ModelRequest{
-		Name: modelName,
-	}
-	jsonData, _ := json.Marshal(modelRequest)
-	fullURL := ollamaAPI + "/api/pull"
-	fmt.Println("Pulling model " + modelName + ", Please wait...")
-	resp, err := http.Post(fullURL, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
-	}
-	defer resp.Body.Close()
+	chart := container.NewStack()
+	modelSelect := widget.NewSelect(models, func(model string) {
+		var results []*BenchmarkResult
+		for _, benchmarkResult := range history {
+			if model == allModels || benchmarkResult.ModelName == model {
+				results = append(results, benchmarkResult)
+			}
+		}
+		chart.Objects = []fyne.CanvasObject{historyChart(results)}
+		chart.Refresh()
+	})
+	modelSelect.SetSelected(allModels)
 
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Error pulling model:", string(body))
-		return
+	w.SetContent(container.NewPadded(container.NewBorder(modelSelect, nil, nil, nil, chart)))
+	w.Show()
+}
+
+// historyChart draws the tokens per second of the results over time, one line per model
+// in the order of first appearance, with the results sorted by timestamp
+func historyChart(results []*BenchmarkResult) fyne.CanvasObject {
+	if len(results) == 0 {
+		return widget.NewLabel("No benchmark history yet, completed benchmarks are added to " + historyPath())
 	}
 
-	fmt.Println("Model pulled successfully")
-	fmt.Println("Benchmarking...")
-	start := time.Now()
+	const left, top, right, bottom = 60, 10, 10, 50
+	plotWidth := historyChartSize.Width - left - right
+	plotHeight := historyChartSize.Height - top - bottom
 
-	for i := 0; i < iterations; i++ {
-		requestBody := OllamaRequest{
-			ModelName: modelName,
-			Prompt:    "Tell me about Llamas in 500 words.",
+	first, last := results[0].Timestamp, results[len(results)-1].Timestamp
+	maxTPS := 0.0
+	for _, benchmarkResult := range results {
+		maxTPS = math.Max(maxTPS, benchmarkResult.TokensPerSecond)
+	}
+	if maxTPS == 0 {
+		maxTPS = 1
+	}
+	point := func(benchmarkResult *BenchmarkResult) fyne.Position {
+		x := float32(0.5)
+		if last > first {
+			x = float32(benchmarkResult.Timestamp-first) / float32(last-first)
 		}
+		y := float32(benchmarkResult.TokensPerSecond / maxTPS)
+		return fyne.NewPos(left+x*plotWidth, top+(1-y)*plotHeight)
+	}
 
-		jsonData, _ := json.Marshal(requestBody)
-		resp, err := http.Post(ollamaAPIURL+"/api/generate", "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			fmt.Println("Error:", err)
-			return
+	var objects []fyne.CanvasObject
+	axis := func(from, to fyne.Position) {
+		line := canvas.NewLine(theme.ForegroundColor())
+		line.Position1, line.Position2 = from, to
+		objects = append(objects, line)
+	}
+	label := func(text string, pos fyne.Position, alignment fyne.TextAlign) {
+		t := canvas.NewText(text, theme.ForegroundColor())
+		t.TextSize = 11
+		t.Alignment = alignment
+		size := t.MinSize()
+		switch alignment {
+		case fyne.TextAlignTrailing:
+			pos.X -= size.Width
+		case fyne.TextAlignCenter:
+			pos.X -= size.Width / 2
 		}
-		defer resp.Body.Close()
-
-		var response OllamaResponse
-		var responseText string
-		decoder := json.NewDecoder(resp.Body)
-
-		fmt.Printf("Benchmarking iteration %d in progress..", i+1)
-		progressTicker := time.NewTicker(500 * time.Millisecond)
-		defer progressTicker.Stop()
-
-		done := make(chan bool)
-		go func() {
-			for {
-				select {
-				case <-progressTicker.C:
-					fmt.Print(".")
-				case <-done:
-					fmt.Println()
-					return
-				}
-			}
-		}()
+		t.Move(pos)
+		t.Resize(size)
+		objects = append(objects, t)
+	}
+	axis(fyne.NewPos(left, top), fyne.NewPos(left, top+plotHeight))
+	axis(fyne.NewPos(left, top+plotHeight), fyne.NewPos(left+plotWidth, top+plotHeight))
+	label(fmt.Sprintf("%.0f tok/s", maxTPS), fyne.NewPos(left-6, top-6), fyne.TextAlignTrailing)
+	label("0", fyne.NewPos(left-6, top+plotHeight-8), fyne.TextAlignTrailing)
+	label(time.Unix(first, 0).Format("2006-01-02"), fyne.NewPos(left, top+plotHeight+4), fyne.TextAlignLeading)
+	if last > first {
+		label(time.Unix(last, 0).Format("2006-01-02"), fyne.NewPos(left+plotWidth, top+plotHeight+4), fyne.TextAlignTrailing)
+	}
 
-		for {
-			err := decoder.Decode(&response)
-			if err == io.EOF {
-				done <- true
-				break
-			}
-			if err != nil {
-				fmt.Println("\nError:", err)
-				done <- true
-				return
+	// Group the results by model, keeping the order of first appearance for the colors
+	var models []string
+	series := make(map[string][]*BenchmarkResult)
+	for _, benchmarkResult := range results {
+		if _, ok := series[benchmarkResult.ModelName]; !ok {
+			models = append(models, benchmarkResult.ModelName)
+		}
+		series[benchmarkResult.ModelName] = append(series[benchmarkResult.ModelName], benchmarkResult)
+	}
+	legendX := float32(left)
+	for i, model := range models {
+		lineColor := historyChartColors[i%len(historyChartColors)]
+		var previous *fyne.Position
+		for _, benchmarkResult := range series[model] {
+			pos := point(benchmarkResult)
+			if previous != nil {
+				line := canvas.NewLine(lineColor)
+				line.StrokeWidth = 2
+				line.Position1, line.Position2 = *previous, pos
+				objects = append(objects, line)
 			}
-
-			responseText += response.Response
+			dot := canvas.NewCircle(lineColor)
+			dot.Move(pos.SubtractXY(3, 3))
+			dot.Resize(fyne.NewSize(6, 6))
+			objects = append(objects, dot)
+			previous = &pos
 		}
 
-		// duration := time.Since(start).Seconds()
-		tokensPerSecond := float64(response.EvalCount) / (float64(response.EvalDuration) / 1e9)
+		swatch := canvas.NewRectangle(lineColor)
+		swatch.Move(fyne.NewPos(legendX, top+plotHeight+28))
+		swatch.Resize(fyne.NewSize(10, 10))
+		objects = append(objects, swatch)
+		name := canvas.NewText(model, theme.ForegroundColor())
+		name.TextSize = 11
+		name.Move(fyne.NewPos(legendX+14, top+plotHeight+25))
+		name.Resize(name.MinSize())
+		objects = append(objects, name)
+		legendX += 14 + name.MinSize().Width + 16
+	}
 
-		totalTokensPerSecond += tokensPerSecond
-		evalCount = response.EvalCount
-		evalDuration = float64(response.EvalDuration) / 1e9
+	chart := container.NewWithoutLayout(objects...)
+	chart.Resize(historyChartSize)
+	background := canvas.NewRectangle(color.Transparent)
+	background.SetMinSize(historyChartSize)
+	return container.NewStack(background, chart)
+}
+
+// setTheme switches the GUI between the dark and the light theme
+func setTheme(a fyne.App, dark bool) {
+	if dark {
+		a.Settings().SetTheme(theme.DarkTheme())
+	} else {
+		a.Settings().SetTheme(theme.LightTheme())
+	}
+}
+
+// defaultAssetsDir returns the directory of the executable, where the GUI looks for its
+// assets by default, falling back to the working directory
+func defaultAssetsDir() string {
+	executable, err := os.Executable()
+	if err != nil {
+		return "."
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+	return filepath.Dir(executable)
+}
+
+// isFlagSet reports whether the flag was given on the command line or set by a profile
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// usageError prints why the CLI arguments were rejected, followed by the usage, and exits
+func usageError(msg string) {
+	fmt.Fprintln(os.Stderr, "Error:", msg)
+	fmt.Fprintln(os.Stderr)
+	flag.Usage()
+	os.Exit(1)
+}
+
+func contains(models []ModelInfo, modelName string) bool {
+	for _, model := range models {
+		if model.Name == modelName {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultMinTokens rejects a first iteration that generated a single token,
+// which usually means the generation failed right away
+const defaultMinTokens = 2
+
+// checkFirstIteration returns an error if the first measured generation
+// produced a result that can't be meaningful
+func checkFirstIteration(response OllamaResponse, minTokens int) error {
+	if response.EvalCount == 0 {
+		return fmt.Errorf("model returned no tokens, is the model name correct or did generation fail?")
+	}
+	if response.EvalDuration <= 0 {
+		return fmt.Errorf("model reported %d tokens without an eval duration, tokens per second would be infinite", response.EvalCount)
+	}
+	if response.EvalCount < minTokens {
+		return fmt.Errorf("model returned only %d tokens, expected at least %d, did generation stop early?", response.EvalCount, minTokens)
+	}
+	return nil
+}
+
+// runBenchmark pulls and benchmarks the model described by opts, writing progress to out.
+// If ctx is cancelled while measuring, the iterations completed so far are returned
+// as a result marked Cancelled.
+func runBenchmark(ctx context.Context, opts BenchmarkOptions, out io.Writer) (*BenchmarkResult, error) {
+	setupStart := time.Now()
+	modelName := opts.ModelName
+	ollamaAPIURL := opts.OllamaAPI
+	iterations := opts.Iterations
+
+	var totalTokensPerSecond float64
+	var evalCount int
+	var evalDuration float64
+	var degraded bool
+	var suspicious bool
+	var cancelled bool
+	var completedIterations int
+
+	// Model, prompt, options and format shared by every generation of the benchmark
+	base := OllamaRequest{ModelName: modelName, Prompt: benchmarkPrompt, Chat: opts.Chat}
+	if opts.Prompt != "" {
+		base.Prompt = opts.Prompt
+	}
+	options := make(map[string]interface{})
+	if opts.Threads > 0 {
+		options["num_thread"] = opts.Threads
+	}
+	if opts.NumPredict > 0 {
+		options["num_predict"] = opts.NumPredict
+	}
+	if opts.Seed != nil {
+		options["seed"] = *opts.Seed
+	}
+	if opts.Temperature != nil {
+		options["temperature"] = *opts.Temperature
+	}
+	if opts.NumCtx > 0 {
+		options["num_ctx"] = opts.NumCtx
+	}
+	if len(options) > 0 {
+		base.Options = options
+	}
+	if opts.FormatJSON {
+		base.Format = "json"
+		base.Prompt += jsonPromptSuffix
+	}
+
+	if opts.Submit && modelListOffline {
+		return nil, errModelListOffline
+	}
+
+	// Only models in MODELS can be submitted, others such as own fine-tunes are benchmarked
+	// as installed, without pulling them
+	listed := contains(globalModels, modelName)
+	if !listed && opts.Submit {
+		return nil, fmt.Errorf("model not supported. Please use a supported model from the list: %v", globalModels)
+	}
+
+	sysinfo, err := getSysInfo()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "CPU: %+v\n", sysinfo.CPUName)
+	fmt.Fprintf(out, "Memory: %+v\n", sysinfo.Memory)
+	fmt.Fprintf(out, "OS: %+v\n", sysinfo.OS)
+	fmt.Fprintf(out, "Kernel: %+v\n", sysinfo.Kernel)
+
+	gpus, err := getAllGPUInfo()
+	if err != nil {
+		return nil, err
+	}
+	gpuinfo, err := selectGPU(gpus, gpuSelector)
+	if err != nil {
+		return nil, err
+	}
+	if len(gpus) > 1 {
+		for _, gpu := range gpus {
+			fmt.Fprintf(out, "Detected GPU: %s (%s)\n", gpu.Name, gpu.Vendor)
+		}
+		fmt.Fprintln(out, "Several GPUs detected, benchmarking the GPU below, select another with -gpu")
+	}
+	fmt.Fprintf(out, "GPU Name: %+v\n", gpuinfo.Name)
+	fmt.Fprintf(out, "Driver Version: %+v\n", gpuinfo.DriverVersion)
+	fmt.Fprintf(out, "GPU Memory: %+v\n", gpuinfo.Memory)
+
+	switch {
+	case !listed:
+		fmt.Fprintf(out, "Note: %s isn't in the Ollamark model list, benchmarking the installed model without pulling it. The result can't be submitted.\n", modelName)
+	case opts.NoPull:
+		fmt.Fprintln(out, "Not pulling model "+modelName+" (-pull=false)")
+	case modelInstalled(ollamaAPIURL, modelName):
+		fmt.Fprintln(out, "Model "+modelName+" is already installed, skipping the pull")
+	default:
+		modelRequest := ModelRequest{
+			Name: modelName,
+		}
+		jsonData, _ := json.Marshal(modelRequest)
+		fullURL := ollamaAPIURL + "/api/pull"
+		fmt.Fprintln(out, "Pulling model "+modelName+", Please wait...")
+		resp, err := ollamaPostContext(ctx, fullURL, jsonData)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("error pulling model: %s", body)
+		}
+
+		fmt.Fprintln(out, "Model pulled successfully")
+	}
+
+	modelDigest, err := getModelDigest(ollamaAPIURL, modelName)
+	if err != nil {
+		return nil, err
+	}
+	if !digestMatches(modelDigest, opts.Digest) {
+		return nil, fmt.Errorf("model digest mismatch for %s: expected %s, got %s", modelName, opts.Digest, modelDigest)
+	}
+	fmt.Fprintln(out, "Model Digest:", modelDigest)
+
+	// Model details are informational, older Ollama versions may not provide them
+	modelDetails, err := showModel(ollamaAPIURL, modelName)
+	if err != nil {
+		fmt.Fprintln(out, "Failed to get model details:", err)
+	} else {
+		fmt.Fprintf(out, "Model Parameters: %s\n", modelDetails.ParameterSize)
+		fmt.Fprintf(out, "Model Quantization: %s\n", modelDetails.QuantizationLevel)
+		fmt.Fprintf(out, "Model Context Length: %d\n", modelDetails.ContextLength)
+	}
+
+	// Load the model with a cheap generation so loading time isn't measured
+	if opts.WarmupPrompt != "" {
+		fmt.Fprintln(out, "Warming up model...")
+		warmupRequest := base
+		warmupRequest.Prompt = opts.WarmupPrompt
+		if _, _, err := generate(ctx, ollamaAPIURL, warmupRequest); err != nil {
+			return nil, err
+		}
+	}
+
+	// Run the benchmark prompt itself a few times unmeasured, so caches are as warm as they get
+	if opts.Warmup > 0 {
+		fmt.Fprintf(out, "Warming up (%d runs)...\n", opts.Warmup)
+		for i := 0; i < opts.Warmup; i++ {
+			if _, _, err := generate(ctx, ollamaAPIURL, base); err != nil {
+				return nil, fmt.Errorf("warmup run %d: %v", i+1, err)
+			}
+		}
+	}
+
+	var prompts []string
+	var promptSetHash string
+	if opts.PromptSet != "" {
+		prompts, promptSetHash, err = loadPromptSet(opts.PromptSet)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Fprintln(out, "Benchmarking...")
+	start := time.Now()
+	sampler := startGPUSampler(time.Second)
+	defer sampler.stop()
+
+	var avgTokensPerSecond float64
+	var generationTPS []float64
+	var iterationTPS []float64 // tokens per second of each iteration or generation, for the spread
+	var timings ollamaTimings
+	if len(prompts) > 0 {
+		fmt.Fprintf(out, "Generating %d tokens for each of %d prompts...\n", promptSetNumPredict, len(prompts))
+		totals, err := benchmarkPromptSet(ctx, ollamaAPIURL, base, prompts)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		cancelled = ctx.Err() != nil
+		avgTokensPerSecond, evalCount, evalDuration, degraded = totals.tokensPerSecond(), totals.evalCount, totals.evalDuration, totals.degraded
+		suspicious, timings = totals.suspicious, totals.timings
+		iterations, completedIterations = len(prompts), totals.generations
+	} else if opts.TotalTokens > 0 {
+		fmt.Fprintf(out, "Generating until %d tokens...\n", opts.TotalTokens)
+		totals, err := benchmarkTotalTokens(ctx, ollamaAPIURL, base, opts.TotalTokens, out)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		cancelled = ctx.Err() != nil
+		avgTokensPerSecond, evalCount, evalDuration, degraded = totals.tokensPerSecond(), totals.evalCount, totals.evalDuration, totals.degraded
+		suspicious, timings = totals.suspicious, totals.timings
+		iterations, completedIterations = totals.generations, totals.generations
+		fmt.Fprintf(out, "Generated %d tokens in %d responses, wall time %.2fs (%.*f tokens per second)\n", evalCount, iterations, time.Since(start).Seconds(), tpsPrecision, float64(evalCount)/time.Since(start).Seconds())
+	} else if opts.Duration > 0 {
+		fmt.Fprintf(out, "Generating for %s...\n", opts.Duration)
+		totals, trend, err := benchmarkDuration(ctx, ollamaAPIURL, base, opts.Duration, out)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		cancelled = ctx.Err() != nil
+		avgTokensPerSecond, evalCount, evalDuration, degraded = totals.tokensPerSecond(), totals.evalCount, totals.evalDuration, totals.degraded
+		suspicious, timings = totals.suspicious, totals.timings
+		iterations, completedIterations = totals.generations, totals.generations
+		generationTPS, iterationTPS = trend, trend
+		fmt.Fprintf(out, "%d generations completed within %s, %d tokens in total\n", totals.generations, opts.Duration, evalCount)
+		if len(trend) >= 2 {
+			fmt.Fprintf(out, "Trend: %+.1f%% tokens per second from the first to the second half of the window, a drop suggests throttling\n", tpsTrendPercent(trend))
+		}
+	} else {
+		for i := 0; i < iterations; i++ {
+			requestBody := base
+
+			resp, err := ollamaPostContext(ctx, ollamaAPIURL+requestBody.path(), requestBody.body())
+			if err != nil {
+				if ctx.Err() != nil {
+					cancelled = true
+					break
+				}
+				return nil, err
+			}
+			defer resp.Body.Close()
+
+			// Rewrite the line with the running rate as tokens arrive, the final average stays authoritative
+			fmt.Fprintf(out, "Benchmarking iteration %d in progress...", i+1)
+			var lastUpdate time.Time
+			liveRate := func(chunks int, elapsed time.Duration) {
+				if opts.JSON || chunks < 2 || elapsed <= 0 || time.Since(lastUpdate) < liveRateInterval {
+					return
+				}
+				lastUpdate = time.Now()
+				line := fmt.Sprintf("Benchmarking iteration %d: %d tokens, %.*f tokens per second", i+1, chunks, tpsPrecision, float64(chunks-1)/elapsed.Seconds())
+				fmt.Fprintf(out, "\r%-70s", line)
+			}
+
+			response, text, err := decodeGenerateStream(resp.Body, liveRate)
+			fmt.Fprintln(out)
+			if ctx.Err() != nil {
+				cancelled = true
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if response.Partial {
+				degraded = true
+			}
+			if !tokenCountConsistent(response.EvalCount, text) {
+				suspicious = true
+			}
+
+			// Don't spend the remaining iterations on a broken generation
+			if completedIterations == 0 && !opts.Force {
+				if err := checkFirstIteration(response, opts.MinTokens); err != nil {
+					return nil, fmt.Errorf("%v (use -force to benchmark anyway)", err)
+				}
+			}
+
+			// duration := time.Since(start).Seconds()
+			tokensPerSecond := float64(response.EvalCount) / (float64(response.EvalDuration) / 1e9)
+
+			totalTokensPerSecond += tokensPerSecond
+			iterationTPS = append(iterationTPS, tokensPerSecond)
+			timings.add(response)
+			evalCount = response.EvalCount
+			evalDuration = float64(response.EvalDuration) / 1e9
+			completedIterations++
+		}
+		if completedIterations > 0 {
+			avgTokensPerSecond = totalTokensPerSecond / float64(completedIterations)
+		}
+	}
+
+	measuredDuration := time.Since(start).Seconds()
+	EvalCount := evalCount
+	EvalDuration := evalDuration
+
+	// The prompt set replaces the default prompt
+	prompt := base.Prompt
+	if promptSetHash != "" {
+		prompt = ""
+	}
+
+	if cancelled {
+		fmt.Fprintf(out, "\nBenchmark cancelled for %s after %d of %d iterations\n", modelName, completedIterations, iterations)
+	} else {
+		fmt.Fprintf(out, "\nBenchmark completed for %s\n", modelName)
+	}
+	fmt.Fprintf(out, "Average Tokens per second: %.*f\n", tpsPrecision, avgTokensPerSecond)
+	minTPS, maxTPS, stdDev := tpsSpread(iterationTPS)
+	if len(iterationTPS) > 1 {
+		fmt.Fprintf(out, "Min: %.*f, Max: %.*f, Std Dev: %.*f tokens per second\n", tpsPrecision, minTPS, tpsPrecision, maxTPS, tpsPrecision, stdDev)
+	}
+	if promptTPS := timings.promptTokensPerSecond(); promptTPS > 0 {
+		fmt.Fprintf(out, "Prompt processing: %.*f tokens per second (%d prompt tokens)\n", tpsPrecision, promptTPS, timings.promptEvalCount)
+	}
+	if degraded {
+		fmt.Fprintln(out, "Warning: a response stream broke off, the result is partly estimated and flagged as degraded")
+	}
+	if suspicious {
+		fmt.Fprintln(out, "Warning: the reported token count doesn't match the generated text, the result is flagged as suspicious")
+	}
+
+	gpuUsage := sampler.stop()
+	sysinfo, _ = getSysInfo()
+	gpuinfo, _ = getGPUInfo()
+	if gpuinfo != nil && gpuUsage != nil {
+		gpuinfo.Usage = gpuUsage
+		fmt.Fprintf(out, "GPU Utilization: %.0f%% (SM clock %.0f MHz, memory clock %.0f MHz)\n", gpuUsage.UtilizationPercent, gpuUsage.SMClockMHz, gpuUsage.MemoryClockMHz)
+	}
+
+	if warning := checkGPUOffload(ollamaAPIURL, modelName, gpuinfo); warning != "" {
+		fmt.Fprintln(out, "Warning:", warning)
+	}
+
+	benchmarkResult := &BenchmarkResult{
+		ModelName:           modelName,
+		ModelDigest:         modelDigest,
+		ModelDetails:        modelDetails,
+		Prompt:              prompt,
+		PromptHash:          promptHash(prompt),
+		WarmupPrompt:        opts.WarmupPrompt,
+		WarmupPromptHash:    promptHash(opts.WarmupPrompt),
+		WarmupRuns:          opts.Warmup,
+		PromptSetHash:       promptSetHash,
+		TotalTokens:         opts.TotalTokens,
+		Threads:             opts.Threads,
+		NumPredict:          opts.NumPredict,
+		Seed:                opts.Seed,
+		Temperature:         opts.Temperature,
+		NumCtx:              opts.NumCtx,
+		Format:              base.Format,
+		Chat:                opts.Chat,
+		DurationTarget:      opts.Duration.Seconds(),
+		GenerationTPS:       generationTPS,
+		MinTPS:              minTPS,
+		MaxTPS:              maxTPS,
+		StdDev:              stdDev,
+		Timestamp:           time.Now().Unix(),
+		Duration:            measuredDuration,
+		TotalDurationSec:    measuredDuration,
+		SetupDurationSec:    start.Sub(setupStart).Seconds(),
+		EvalCount:           EvalCount,
+		EvalDuration:        int64(EvalDuration),
+		TokensPerSecond:     avgTokensPerSecond,
+		Iterations:          iterations,
+		SysInfo:             sysinfo,
+		GPUInfo:             gpuinfo,
+		GPUs:                gpus,
+		OllamaVersion:       getOllamaVersion(),
+		ClientType:          "ollamark-cli",
+		ClientVersion:       clientVersion,
+		IP:                  getIPAddress(),
+		MachineID:           machineFingerprint(sysinfo, gpuinfo),
+		Environment:         detectEnvironment(),
+		Degraded:            degraded,
+		Suspicious:          suspicious,
+		Cancelled:           cancelled,
+		CompletedIterations: completedIterations,
+		Tags:                opts.Tags,
+	}
+	timings.apply(benchmarkResult)
+
+	if opts.CompareStream && !cancelled {
+		fmt.Fprintln(out, "Comparing streaming and non-streaming throughput...")
+		comparison, err := compareStreaming(ctx, ollamaAPIURL, base, iterations)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "Streaming Tokens per second: %.*f\n", tpsPrecision, comparison.StreamTokensPerSecond)
+		fmt.Fprintf(out, "Non-streaming Tokens per second: %.*f\n", tpsPrecision, comparison.NonStreamTokensPerSecond)
+		fmt.Fprintf(out, "Streaming overhead: %.2f%%\n", comparison.OverheadPercent)
+		benchmarkResult.StreamComparison = comparison
+	}
+
+	return benchmarkResult, nil
+}
+
+// runBenchmarkCLI runs the benchmark on the terminal and saves, uploads or submits the
+// result. Only a failed benchmark is returned as an error, failing to share the result
+// is reported but leaves the result usable.
+func runBenchmarkCLI(ctx context.Context, opts BenchmarkOptions) (*BenchmarkResult, error) {
+	if err := pingOllama(opts.OllamaAPI); err != nil {
+		return nil, err
+	}
+
+	out := io.Writer(os.Stdout)
+	if opts.JSON {
+		out = os.Stderr
+	}
+	if opts.Quiet {
+		out = io.Discard
+	}
+	benchmarkResult, err := runBenchmark(ctx, opts, out)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Quiet {
+		if !opts.JSON {
+			fmt.Printf("%.*f\n", tpsPrecision, benchmarkResult.TokensPerSecond)
+		}
+	} else {
+		defer printReproducibility(os.Stderr, opts, benchmarkResult)
+	}
+
+	if opts.JSON {
+		data, err := json.MarshalIndent(benchmarkResult, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println(string(data))
+	}
+
+	if opts.Output != "" {
+		if err := saveBenchmarkResult(opts.Output, benchmarkResult); err != nil {
+			fmt.Fprintln(errorMessages, "Error:", err)
+		} else {
+			fmt.Fprintln(messages, "Benchmark results saved to", opts.Output)
+		}
+	}
+
+	// A cancelled benchmark is kept locally but never shared as a full result
+	if benchmarkResult.Cancelled {
+		fmt.Fprintln(messages, "Benchmark cancelled, results not uploaded or submitted.")
+		return benchmarkResult, nil
+	}
+
+	if opts.Save {
+		if err := appendHistory(historyPath(), benchmarkResult); err != nil {
+			fmt.Fprintln(errorMessages, "Error:", err)
+		} else {
+			fmt.Fprintln(messages, "Benchmark result added to the history at", historyPath())
+		}
+	}
+
+	if opts.CSV != "" {
+		if err := appendCSV(opts.CSV, benchmarkResult); err != nil {
+			fmt.Fprintln(errorMessages, "Error:", err)
+		} else {
+			fmt.Fprintln(messages, "Benchmark result appended to", opts.CSV)
+		}
+	}
+
+	if opts.UploadS3 {
+		if err := uploadBenchmarkS3(benchmarkResult); err != nil {
+			fmt.Fprintln(errorMessages, "Error:", err)
+		}
+	}
+
+	if opts.Submit {
+		if benchmarkResult.Suspicious {
+			fmt.Fprintln(messages, "Warning: submitting a result flagged as suspicious, the token counts don't match the generated text")
+		}
+		if err := submitBenchmark(benchmarkResult); err != nil {
+			fmt.Fprintln(errorMessages, "Error:", err)
+		}
+	} else {
+		fmt.Fprintln(messages, "Benchmark results not submitted.")
+	}
+	return benchmarkResult, nil
+}
+
+// shellQuote quotes an argument for a POSIX shell if it contains anything but safe characters
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.:/,=@") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// reproduceCommand returns the ollamark command line that reruns the benchmark with the
+// same parameters, pinned to the benchmarked model digest
+func reproduceCommand(opts BenchmarkOptions, benchmarkResult *BenchmarkResult) string {
+	args := []string{"ollamark", "-m", benchmarkResult.ModelName, "-o", opts.OllamaAPI}
+	if opts.TotalTokens > 0 {
+		args = append(args, "-total-tokens", strconv.Itoa(opts.TotalTokens))
+	} else if opts.Duration > 0 {
+		args = append(args, "-duration", opts.Duration.String())
+	} else if opts.PromptSet == "" {
+		args = append(args, "-i", strconv.Itoa(benchmarkResult.Iterations))
+	}
+	if opts.PromptSet != "" {
+		args = append(args, "-prompt-set", opts.PromptSet)
+	}
+	if opts.Prompt != "" {
+		args = append(args, "-p", opts.Prompt)
+	}
+	if opts.ConfigURL != "" {
+		args = append(args, "-config-url", opts.ConfigURL)
+	}
+	if benchmarkResult.ModelDigest != "" {
+		args = append(args, "-digest", benchmarkResult.ModelDigest)
+	}
+	if opts.WarmupPrompt != defaultWarmupPrompt {
+		args = append(args, "-warmup-prompt", opts.WarmupPrompt)
+	}
+	if opts.Warmup > 0 {
+		args = append(args, "-warmup", strconv.Itoa(opts.Warmup))
+	}
+	if opts.Threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(opts.Threads))
+	}
+	if opts.NumPredict > 0 {
+		args = append(args, "-n", strconv.Itoa(opts.NumPredict))
+	}
+	if opts.Seed != nil {
+		args = append(args, "-seed", strconv.Itoa(*opts.Seed))
+	}
+	if opts.Temperature != nil {
+		args = append(args, "-temp", strconv.FormatFloat(*opts.Temperature, 'f', -1, 64))
+	}
+	if opts.NumCtx > 0 {
+		args = append(args, "-ctx", strconv.Itoa(opts.NumCtx))
+	}
+	if opts.Chat {
+		args = append(args, "-chat")
+	}
+	if opts.FormatJSON {
+		args = append(args, "-format-json")
+	}
+	if opts.CompareStream {
+		args = append(args, "-compare-stream")
+	}
+
+	for i, arg := range args {
+		args[i] = shellQuote(arg)
+	}
+	return strings.Join(args, " ")
+}
+
+// printReproducibility writes the parameters needed to reproduce or share the run in one
+// block, to stderr in the CLI so it stays out of any output on stdout
+func printReproducibility(w io.Writer, opts BenchmarkOptions, benchmarkResult *BenchmarkResult) {
+	numPredict := "Ollama default"
+	if benchmarkResult.PromptSetHash != "" {
+		numPredict = strconv.Itoa(promptSetNumPredict)
+	} else if benchmarkResult.NumPredict > 0 {
+		numPredict = strconv.Itoa(benchmarkResult.NumPredict)
+	}
+	promptHash := benchmarkResult.PromptHash
+	if benchmarkResult.PromptSetHash != "" {
+		promptHash = benchmarkResult.PromptSetHash + " (prompt set)"
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Reproducibility")
+	fmt.Fprintf(w, "  Model:          %s (%s)\n", benchmarkResult.ModelName, benchmarkResult.ModelDigest)
+	fmt.Fprintf(w, "  Iterations:     %d\n", benchmarkResult.Iterations)
+	fmt.Fprintf(w, "  Prompt hash:    %s\n", promptHash)
+	fmt.Fprintf(w, "  num_predict:    %s\n", numPredict)
+	seed := "not set (Ollama default)"
+	if benchmarkResult.Seed != nil {
+		seed = strconv.Itoa(*benchmarkResult.Seed)
+	}
+	fmt.Fprintf(w, "  Seed:           %s\n", seed)
+	fmt.Fprintf(w, "  Ollama version: %s\n", benchmarkResult.OllamaVersion)
+	api := "/api/generate"
+	if benchmarkResult.Chat {
+		api = "/api/chat"
+	}
+	fmt.Fprintf(w, "  Endpoint:       %s (%s)\n", opts.OllamaAPI, api)
+	fmt.Fprintf(w, "  Command:        %s\n", reproduceCommand(opts, benchmarkResult))
+}
+
+// runThreadsSweep benchmarks the model once per thread count and reports the fastest,
+// to find the best num_thread for CPU inference without trial and error
+func runThreadsSweep(ctx context.Context, opts BenchmarkOptions, threads []int) {
+	tokensPerSecond := make(map[int]float64)
+	for _, n := range threads {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Printf("\nBenchmarking with %d threads\n", n)
+		opts.Threads = n
+		benchmarkResult, err := runBenchmark(ctx, opts, os.Stdout)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		if benchmarkResult.Cancelled {
+			break
+		}
+		tokensPerSecond[n] = benchmarkResult.TokensPerSecond
+	}
+
+	if len(tokensPerSecond) == 0 {
+		fmt.Println("\nNo thread count completed the benchmark.")
+		return
+	}
+
+	fmt.Printf("\nThreads sweep for %s\n", opts.ModelName)
+	best := 0
+	for _, n := range threads {
+		tps, ok := tokensPerSecond[n]
+		if !ok {
+			fmt.Printf("  %3d threads: failed\n", n)
+			continue
+		}
+		fmt.Printf("  %3d threads: %.*f tokens/s\n", n, tpsPrecision, tps)
+		if best == 0 || tps > tokensPerSecond[best] {
+			best = n
+		}
+	}
+	fmt.Printf("Fastest: %d threads (%.*f tokens/s), use -threads %d\n", best, tpsPrecision, tokensPerSecond[best], best)
+}
+
+// modelSummaryRow is one model's line in the summary of a multi-model run
+type modelSummaryRow struct {
+	Model           string
+	TokensPerSecond float64
+	Note            string // Why the model wasn't benchmarked, empty if it was
+}
+
+// printModelSummary prints the benchmarked models from fastest to slowest, followed by
+// the skipped and failed ones
+func printModelSummary(rows []modelSummaryRow) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		if (rows[i].Note == "") != (rows[j].Note == "") {
+			return rows[i].Note == ""
+		}
+		return rows[i].TokensPerSecond > rows[j].TokensPerSecond
+	})
+
+	fmt.Println("\nSummary")
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "MODEL\tTOKENS/S")
+	for _, row := range rows {
+		if row.Note != "" {
+			fmt.Fprintf(writer, "%s\t%s\n", row.Model, row.Note)
+		} else {
+			fmt.Fprintf(writer, "%s\t%.*f\n", row.Model, tpsPrecision, row.TokensPerSecond)
+		}
+	}
+	writer.Flush()
+}
+
+// freeModelMemory estimates the memory available to load a model: free RAM plus the VRAM
+// of a dedicated GPU, or 0 if unknown
+func freeModelMemory() int64 {
+	v, err := mem.VirtualMemory()
+	if err != nil {
+		return 0
+	}
+	free := int64(v.Available)
+	if gpuinfo, err := getGPUInfo(); err == nil && gpuinfo.Memory != "Shared" {
+		if vram, ok := parseMemoryBytes(gpuinfo.Memory); ok {
+			free += vram
+		}
+	}
+	return free
+}
+
+// runLocalModels benchmarks every model installed in Ollama whose name matches the glob
+// pattern (all if empty), skipping models too large for the free memory, and prints a
+// summary sorted by tokens per second
+func runLocalModels(ctx context.Context, opts BenchmarkOptions, pattern string) {
+	installed, err := fetchLocalModels(opts.OllamaAPI)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	freeMemory := freeModelMemory()
+	var rows []modelSummaryRow
+	for _, model := range installed {
+		if ctx.Err() != nil {
+			break
+		}
+		name := strings.TrimSuffix(model.Name, ":latest")
+		if pattern != "" {
+			matchedName, _ := filepath.Match(pattern, name)
+			matchedTag, _ := filepath.Match(pattern, model.Name)
+			if !matchedName && !matchedTag {
+				continue
+			}
+		}
+		if freeMemory > 0 && model.Size > freeMemory {
+			rows = append(rows, modelSummaryRow{Model: name, Note: fmt.Sprintf("skipped, %s doesn't fit in %s free", formatGB(model.Size), formatGB(freeMemory))})
+			continue
+		}
+
+		fmt.Printf("\nBenchmarking %s\n", name)
+		opts.ModelName = name
+		benchmarkResult, err := runBenchmark(ctx, opts, os.Stdout)
+		if err != nil {
+			fmt.Println("Error:", err)
+			rows = append(rows, modelSummaryRow{Model: name, Note: "failed: " + err.Error()})
+			continue
+		}
+		if benchmarkResult.Cancelled {
+			break
+		}
+		rows = append(rows, modelSummaryRow{Model: name, TokensPerSecond: benchmarkResult.TokensPerSecond})
+	}
+
+	if len(rows) == 0 {
+		fmt.Println("\nNo installed models matched.")
+		return
+	}
+	printModelSummary(rows)
+}
+
+// runModels benchmarks each model one after another like a single-model run, continuing
+// after failures, and prints a comparison sorted by tokens per second. It exits with
+// status 1 if any model failed.
+func runModels(ctx context.Context, opts BenchmarkOptions, models []string) {
+	var rows []modelSummaryRow
+	failed := false
+	for _, name := range models {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Printf("\nBenchmarking %s\n", name)
+		opts.ModelName = name
+		benchmarkResult, err := runBenchmarkCLI(ctx, opts)
+		if err != nil {
+			fmt.Fprintln(messages, "Error:", err)
+			rows = append(rows, modelSummaryRow{Model: name, Note: "failed: " + err.Error()})
+			failed = true
+			continue
+		}
+		if benchmarkResult.Cancelled {
+			break
+		}
+		rows = append(rows, modelSummaryRow{Model: name, TokensPerSecond: benchmarkResult.TokensPerSecond})
+	}
+
+	if len(rows) > 0 {
+		printModelSummary(rows)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// saveBenchmarkResult writes the benchmark result as indented JSON to path
+func saveBenchmarkResult(path string, benchmarkResult *BenchmarkResult) error {
+	data, err := json.MarshalIndent(benchmarkResult, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// loadBenchmarkResult reads a benchmark result saved by saveBenchmarkResult
+func loadBenchmarkResult(path string) (*BenchmarkResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var benchmarkResult BenchmarkResult
+	if err := json.Unmarshal(data, &benchmarkResult); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return &benchmarkResult, nil
+}
+
+// historyPath returns the local JSON-lines history file, OLLAMARK_HISTORY or
+// history.jsonl in the user's ollamark config directory
+func historyPath() string {
+	if path := os.Getenv("OLLAMARK_HISTORY"); path != "" {
+		return path
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "ollamark-history.jsonl"
+	}
+	return filepath.Join(configDir, "ollamark", "history.jsonl")
+}
+
+// defaultHistoryLimit is how many results the history keeps unless OLLAMARK_HISTORY_LIMIT
+// says otherwise
+const defaultHistoryLimit = 1000
+
+// historyLimit returns the maximum number of results in the history, 0 for no limit
+func historyLimit() int {
+	if limit, err := strconv.Atoi(os.Getenv("OLLAMARK_HISTORY_LIMIT")); err == nil && limit >= 0 {
+		return limit
+	}
+	return defaultHistoryLimit
+}
+
+// appendHistory appends the benchmark result as one JSON line to the history file,
+// dropping the oldest lines beyond historyLimit
+func appendHistory(path string, benchmarkResult *BenchmarkResult) error {
+	data, err := json.Marshal(benchmarkResult)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return trimHistory(path, historyLimit())
+}
+
+// trimHistory keeps only the last limit lines of the history file
+func trimHistory(path string, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(strings.TrimRight(string(data), "\n")+"\n", "\n")
+	lines = lines[:len(lines)-1]
+	if len(lines) <= limit {
+		return nil
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines[len(lines)-limit:], "")), 0644)
+}
+
+// csvHeader names the columns of the -csv file
+var csvHeader = []string{"model", "timestamp", "tokens_per_second", "eval_count", "eval_duration", "iterations", "cpu_name", "gpu_name", "ollama_version"}
+
+// appendCSV appends the benchmark result as one row to the CSV file at path, starting a
+// new file with the header row. Unlike the history, the directory has to exist.
+func appendCSV(path string, benchmarkResult *BenchmarkResult) error {
+	if info, err := os.Stat(filepath.Dir(path)); err != nil || !info.IsDir() {
+		return fmt.Errorf("can't write CSV to %s: directory %s doesn't exist", path, filepath.Dir(path))
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	var cpuName, gpuName string
+	if benchmarkResult.SysInfo != nil {
+		cpuName = benchmarkResult.SysInfo.CPUName
+	}
+	if benchmarkResult.GPUInfo != nil {
+		gpuName = benchmarkResult.GPUInfo.Name
+	}
+
+	writer := csv.NewWriter(file)
+	if info.Size() == 0 {
+		writer.Write(csvHeader)
+	}
+	writer.Write([]string{
+		benchmarkResult.ModelName,
+		time.Unix(benchmarkResult.Timestamp, 0).Format(time.RFC3339),
+		strconv.FormatFloat(benchmarkResult.TokensPerSecond, 'f', -1, 64),
+		strconv.Itoa(benchmarkResult.EvalCount),
+		strconv.FormatInt(benchmarkResult.EvalDuration, 10),
+		strconv.Itoa(benchmarkResult.Iterations),
+		cpuName,
+		gpuName,
+		benchmarkResult.OllamaVersion,
+	})
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// loadHistory reads the results of the history file, skipping lines that can't be
+// parsed and returning how many were skipped
+func loadHistory(path string) ([]*BenchmarkResult, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var results []*BenchmarkResult
+	skipped := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var benchmarkResult BenchmarkResult
+		if err := json.Unmarshal(line, &benchmarkResult); err != nil {
+			skipped++
+			continue
+		}
+		results = append(results, &benchmarkResult)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return results, skipped, nil
+}
+
+// runLog implements "ollamark log": it prints the results saved with -save,
+// filtered by model, limited to the most recent and sorted by date or tokens per second
+func runLog(args []string) {
+	logFlags := flag.NewFlagSet("log", flag.ExitOnError)
+	modelPtr := logFlags.String("model", "", "Only show results of this model")
+	lastPtr := logFlags.Int("last", 20, "Number of most recent results to show, 0 for all")
+	sortPtr := logFlags.String("sort", "date", "Sort by \"date\" (oldest first) or \"tps\" (fastest first)")
+	formatPtr := logFlags.String("format", "table", "Output format: \"table\", \"json\" (one result per line) or \"csv\"")
+	precisionPtr := logFlags.Int("precision", 2, "Decimal places of tokens per second in the table and CSV")
+	logFlags.Parse(args)
+
+	if logFlags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Error: unexpected arguments: %s\n", strings.Join(logFlags.Args(), " "))
+		os.Exit(1)
+	}
+	if *lastPtr < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -last must not be negative, got %d\n", *lastPtr)
+		os.Exit(1)
+	}
+	if *sortPtr != "date" && *sortPtr != "tps" {
+		fmt.Fprintf(os.Stderr, "Error: -sort must be \"date\" or \"tps\", got %q\n", *sortPtr)
+		os.Exit(1)
+	}
+	if *formatPtr != "table" && *formatPtr != "json" && *formatPtr != "csv" {
+		fmt.Fprintf(os.Stderr, "Error: -format must be \"table\", \"json\" or \"csv\", got %q\n", *formatPtr)
+		os.Exit(1)
+	}
+	if *precisionPtr < 0 || *precisionPtr > 10 {
+		fmt.Fprintf(os.Stderr, "Error: precision must be between 0 and 10, got %d\n", *precisionPtr)
+		os.Exit(1)
+	}
+	tpsPrecision = *precisionPtr
+
+	path := historyPath()
+	history, skipped, err := loadHistory(path)
+	if os.IsNotExist(err) {
+		fmt.Printf("No benchmark history at %s yet, add results with \"ollamark -m <model> -save\"\n", path)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %d corrupt lines in %s\n", skipped, path)
+	}
+
+	var results []*BenchmarkResult
+	for _, benchmarkResult := range history {
+		if *modelPtr == "" || benchmarkResult.ModelName == *modelPtr {
+			results = append(results, benchmarkResult)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Timestamp < results[j].Timestamp
+	})
+	if *lastPtr > 0 && len(results) > *lastPtr {
+		results = results[len(results)-*lastPtr:]
+	}
+	if *sortPtr == "tps" {
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].TokensPerSecond > results[j].TokensPerSecond
+		})
+	}
+
+	switch *formatPtr {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, benchmarkResult := range results {
+			encoder.Encode(benchmarkResult)
+		}
+	case "csv":
+		writer := csv.NewWriter(os.Stdout)
+		writer.Write([]string{"date", "model", "tokens_per_second", "iterations", "machine", "ollama_version"})
+		for _, benchmarkResult := range results {
+			writer.Write([]string{
+				time.Unix(benchmarkResult.Timestamp, 0).Format(time.RFC3339),
+				benchmarkResult.ModelName,
+				strconv.FormatFloat(benchmarkResult.TokensPerSecond, 'f', tpsPrecision, 64),
+				strconv.Itoa(benchmarkResult.Iterations),
+				machineLabel(benchmarkResult),
+				benchmarkResult.OllamaVersion,
+			})
+		}
+		writer.Flush()
+	default:
+		if len(results) == 0 {
+			fmt.Println("No matching results in the history.")
+			return
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "DATE\tMODEL\tTOKENS/S\tITERATIONS\tMACHINE\tOLLAMA")
+		for _, benchmarkResult := range results {
+			fmt.Fprintf(writer, "%s\t%s\t%.*f\t%d\t%s\t%s\n",
+				time.Unix(benchmarkResult.Timestamp, 0).Format("2006-01-02 15:04"),
+				benchmarkResult.ModelName,
+				tpsPrecision, benchmarkResult.TokensPerSecond,
+				benchmarkResult.Iterations,
+				machineLabel(benchmarkResult),
+				benchmarkResult.OllamaVersion)
+		}
+		writer.Flush()
+	}
+}
+
+// Default drop in tokens per second, in percent, that "ollamark regress" reports as a regression
+const defaultRegressThreshold = 5.0
+
+// runRegress implements "ollamark regress": it reruns the benchmark of a saved baseline
+// with the same parameters and reports the change, exiting with status 1 on a regression
+func runRegress(args []string) {
+	regressFlags := flag.NewFlagSet("regress", flag.ExitOnError)
+	baselinePtr := regressFlags.String("baseline", "", "Benchmark result JSON saved with -out to compare against")
+	ollamaPtr := regressFlags.String("o", "http://localhost:11434", "Ollama API endpoint")
+	promptSetPtr := regressFlags.String("prompt-set", "", "Prompt set file, required if the baseline used one")
+	precisionPtr := regressFlags.Int("precision", 2, "Decimal places of tokens per second in the output")
+	thresholdPtr := regressFlags.Float64("threshold", defaultRegressThreshold, "Drop in tokens per second, in percent, that counts as a regression")
+	regressFlags.Parse(args)
+
+	if *baselinePtr == "" {
+		fmt.Fprintln(os.Stderr, "Error: -baseline is required")
+		regressFlags.Usage()
+		os.Exit(1)
+	}
+	if *precisionPtr < 0 || *precisionPtr > 10 {
+		fmt.Fprintf(os.Stderr, "Error: precision must be between 0 and 10, got %d\n", *precisionPtr)
+		os.Exit(1)
+	}
+	tpsPrecision = *precisionPtr
+
+	baseline, err := loadBenchmarkResult(*baselinePtr)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	// Only the software may differ from the baseline, otherwise the delta is meaningless
+	sysinfo, _ := getSysInfo()
+	gpuinfo, _ := getGPUInfo()
+	if machineID := machineFingerprint(sysinfo, gpuinfo); baseline.MachineID != "" && machineID != baseline.MachineID {
+		fmt.Println("Error: the baseline was recorded on different hardware, refusing to compare")
+		os.Exit(1)
+	}
+	if baseline.PromptSetHash != "" && *promptSetPtr == "" {
+		fmt.Println("Error: the baseline used a prompt set, pass the same file with -prompt-set")
+		os.Exit(1)
+	}
+
+	apiEndpoint = *ollamaPtr
+	useLocalModels(apiEndpoint)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	current, err := runBenchmark(ctx, BenchmarkOptions{
+		ModelName:    baseline.ModelName,
+		OllamaAPI:    apiEndpoint,
+		Iterations:   baseline.Iterations,
+		Digest:       baseline.ModelDigest,
+		WarmupPrompt: baseline.WarmupPrompt,
+		Warmup:       baseline.WarmupRuns,
+		Prompt:       strings.TrimSuffix(baseline.Prompt, jsonPromptSuffix),
+		PromptSet:    *promptSetPtr,
+		TotalTokens:  baseline.TotalTokens,
+		Duration:     time.Duration(baseline.DurationTarget * float64(time.Second)),
+		Threads:      baseline.Threads,
+		NumPredict:   baseline.NumPredict,
+		Seed:         baseline.Seed,
+		Temperature:  baseline.Temperature,
+		NumCtx:       baseline.NumCtx,
+		Chat:         baseline.Chat,
+		FormatJSON:   baseline.Format == "json",
+	}, os.Stdout)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if current.Cancelled {
+		fmt.Println("Error: the benchmark was cancelled, not comparing a partial result")
+		os.Exit(1)
+	}
+	if current.PromptSetHash != baseline.PromptSetHash {
+		fmt.Println("Error: the prompt set differs from the baseline's, refusing to compare")
+		os.Exit(1)
+	}
+
+	delta := (current.TokensPerSecond - baseline.TokensPerSecond) / baseline.TokensPerSecond * 100
+	fmt.Println()
+	fmt.Printf("Baseline: %.*f tokens/s (Ollama %s)\n", tpsPrecision, baseline.TokensPerSecond, baseline.OllamaVersion)
+	fmt.Printf("Current:  %.*f tokens/s (Ollama %s)\n", tpsPrecision, current.TokensPerSecond, current.OllamaVersion)
+	fmt.Printf("Change:   %+.2f%%\n", delta)
+
+	if delta < -*thresholdPtr {
+		fmt.Printf("Regression: tokens per second dropped by more than %.2f%%\n", *thresholdPtr)
+		os.Exit(1)
+	}
+	fmt.Println("No regression detected")
+}
+
+// Memory a large (13B+ parameter, 4-bit quantized) model needs to run comfortably
+const largeModelMemory = 10 << 30
+
+// parseMemoryBytes converts sizes as reported by the GPU tools, e.g. "24576 MiB" or
+// "16GiB", to bytes
+func parseMemoryBytes(size string) (int64, bool) {
+	size = strings.TrimSpace(size)
+	i := strings.IndexFunc(size, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i <= 0 {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(size[:i], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	units := map[string]float64{
+		"b": 1, "kb": 1e3, "kib": 1 << 10, "mb": 1e6, "mib": 1 << 20, "gb": 1e9, "gib": 1 << 30, "tb": 1e12, "tib": 1 << 40,
+	}
+	unit, ok := units[strings.ToLower(strings.TrimSpace(size[i:]))]
+	if !ok {
+		return 0, false
+	}
+	return int64(value * unit), true
+}
+
+// formatGB formats a byte count in GB for the health report
+func formatGB(bytes int64) string {
+	return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+}
+
+// runHealth implements "ollamark health": a pre-flight report of the Ollama instance
+// and this machine's resources, without running a benchmark
+func runHealth(args []string) {
+	healthFlags := flag.NewFlagSet("health", flag.ExitOnError)
+	ollamaPtr := healthFlags.String("o", "http://localhost:11434", "Ollama API endpoint")
+	healthFlags.Parse(args)
+
+	fmt.Println("Ollama")
+	fmt.Println("  Version:", getOllamaVersion())
+	installed, err := fetchLocalModels(*ollamaPtr)
+	if err != nil {
+		fmt.Printf("  Not reachable at %s: %v\n", *ollamaPtr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("  Reachable at %s, %d models installed\n", *ollamaPtr, len(installed))
+
+	running, err := fetchRunningModels(*ollamaPtr)
+	if err != nil {
+		fmt.Println("  Failed to list loaded models:", err)
+	}
+	var loadedVRAM int64
+	if len(running) == 0 {
+		fmt.Println("  No models loaded")
+	}
+	for _, model := range running {
+		loadedVRAM += model.SizeVRAM
+		placement := "CPU"
+		if model.SizeVRAM > 0 {
+			placement = fmt.Sprintf("%.0f%% GPU", float64(model.SizeVRAM)/float64(model.Size)*100)
+		}
+		fmt.Printf("  Loaded: %s (%s, %s)\n", model.Name, formatGB(model.Size), placement)
+	}
+
+	fmt.Println("System")
+	sysinfo, err := getSysInfo()
+	if err == nil {
+		fmt.Printf("  CPU: %s\n", sysinfo.CPUName)
+	}
+	var availableRAM int64
+	if v, err := mem.VirtualMemory(); err == nil {
+		availableRAM = int64(v.Available)
+		fmt.Printf("  RAM: %s available of %s\n", formatGB(availableRAM), formatGB(int64(v.Total)))
+	}
+
+	var vramHeadroom int64
+	gpuinfo, err := getGPUInfo()
+	if err != nil {
+		fmt.Println("  GPU: none detected")
+	} else {
+		fmt.Printf("  GPU: %s (%s)\n", gpuinfo.Name, gpuinfo.Memory)
+		if gpuinfo.Memory == "Shared" {
+			// Integrated GPUs with unified memory can use what RAM is available
+			vramHeadroom = availableRAM
+		} else if vram, ok := parseMemoryBytes(gpuinfo.Memory); ok {
+			vramHeadroom = vram - loadedVRAM
+			fmt.Printf("  VRAM: %s free of %s not used by loaded models\n", formatGB(vramHeadroom), formatGB(vram))
+		}
+	}
 
+	fmt.Println("Readiness")
+	switch {
+	case vramHeadroom >= largeModelMemory:
+		fmt.Println("  Ready to benchmark large models on the GPU")
+	case gpuinfo != nil && availableRAM >= largeModelMemory:
+		fmt.Println("  Large models won't fit in VRAM and will be partly offloaded to the CPU")
+	case availableRAM >= largeModelMemory:
+		fmt.Println("  Large models will run on the CPU only, expect low tokens per second")
+	default:
+		fmt.Printf("  Not enough free memory for large models (about %s needed), benchmark small models only\n", formatGB(largeModelMemory))
+	}
+	if gpuinfo != nil {
+		for _, model := range running {
+			if warning := offloadWarning(model); warning != "" {
+				fmt.Printf("  Warning: %s: %s\n", model.Name, warning)
+			}
+		}
 	}
+}
 
-	EvalCount := evalCount
-	EvalDuration := evalDuration
-	avgTokensPerSecond := totalTokensPerSecond / float64(iterations)
+// chartBar is one bar of a chart: the average tokens per second of a group of results
+type chartBar struct {
+	Label           string
+	TokensPerSecond float64
+	Results         int
+}
+
+// runChart implements "ollamark chart": it renders saved results as an SVG bar chart
+// of the average tokens per second per model or per machine
+func runChart(args []string) {
+	chartFlags := flag.NewFlagSet("chart", flag.ExitOnError)
+	outputPtr := chartFlags.String("o", "chart.svg", "SVG file to write the chart to")
+	byPtr := chartFlags.String("by", "model", "Group results by \"model\" or \"machine\"")
+
+	// Allow flags after the result files, e.g. "ollamark chart results/*.json -o chart.svg"
+	var paths []string
+	for {
+		chartFlags.Parse(args)
+		if chartFlags.NArg() == 0 {
+			break
+		}
+		paths = append(paths, chartFlags.Arg(0))
+		args = chartFlags.Args()[1:]
+	}
 
-	fmt.Printf("\nBenchmark completed for %s\n", modelName)
-	fmt.Printf("Average Tokens per second: %.2f\n", avgTokensPerSecond)
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no result files given")
+		chartFlags.Usage()
+		os.Exit(1)
+	}
+	if *byPtr != "model" && *byPtr != "machine" {
+		fmt.Fprintf(os.Stderr, "Error: -by must be \"model\" or \"machine\", got %q\n", *byPtr)
+		os.Exit(1)
+	}
 
-	sysinfo, _ = getSysInfo()
-	gpuinfo, _ = getGPUInfo()
+	var results []*BenchmarkResult
+	for _, path := range paths {
+		benchmarkResult, err := loadBenchmarkResult(path)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		results = append(results, benchmarkResult)
+	}
 
-	benchmarkResult := &BenchmarkResult{
-		ModelName:       modelName,
-		Timestamp:       time.Now().Unix(),
-		Duration:        time.Since(start).Seconds(),
-		EvalCount:       EvalCount,
-		EvalDuration:    int64(EvalDuration),
-		TokensPerSecond: avgTokensPerSecond,
-		Iterations:      iterations,
-		SysInfo:         sysinfo,
-		GPUInfo:         gpuinfo,
-		OllamaVersion:   getOllamaVersion(),
-		ClientType:      "ollamark-cli",
-		ClientVersion:   clientVersion,
-		IP:              getIPAddress(),
-	}
-
-	if submit {
-		submitBenchmark(benchmarkResult)
-	} else {
-		fmt.Println("Benchmark results not submitted.")
+	bars := chartBars(results, *byPtr)
+	if err := os.WriteFile(*outputPtr, []byte(renderBarChartSVG("Average tokens per second by "+*byPtr, bars)), 0644); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Chart of %d results written to %s\n", len(results), *outputPtr)
+}
+
+// chartBars averages the tokens per second of the results grouped by model or machine,
+// sorted from fastest to slowest
+func chartBars(results []*BenchmarkResult, by string) []chartBar {
+	var bars []chartBar
+	index := map[string]int{}
+	for _, benchmarkResult := range results {
+		label := benchmarkResult.ModelName
+		if by == "machine" {
+			label = machineLabel(benchmarkResult)
+		}
+
+		i, ok := index[label]
+		if !ok {
+			i = len(bars)
+			index[label] = i
+			bars = append(bars, chartBar{Label: label})
+		}
+		bars[i].TokensPerSecond += benchmarkResult.TokensPerSecond
+		bars[i].Results++
+	}
+
+	for i := range bars {
+		bars[i].TokensPerSecond /= float64(bars[i].Results)
+	}
+	sort.SliceStable(bars, func(i, j int) bool {
+		return bars[i].TokensPerSecond > bars[j].TokensPerSecond
+	})
+	return bars
+}
+
+// machineLabel names a machine by its GPU (or CPU) and the start of its fingerprint,
+// which tells apart machines with the same GPU
+func machineLabel(benchmarkResult *BenchmarkResult) string {
+	name := "Unknown"
+	if benchmarkResult.GPUInfo != nil && benchmarkResult.GPUInfo.Name != "" {
+		name = benchmarkResult.GPUInfo.Name
+	} else if benchmarkResult.SysInfo != nil && benchmarkResult.SysInfo.CPUName != "" {
+		name = benchmarkResult.SysInfo.CPUName
+	}
+	if len(benchmarkResult.MachineID) >= 8 {
+		name += " (" + benchmarkResult.MachineID[:8] + ")"
+	}
+	return name
+}
+
+// renderBarChartSVG draws a horizontal bar chart with one labeled bar per entry
+func renderBarChartSVG(title string, bars []chartBar) string {
+	const (
+		width       = 800
+		labelWidth  = 280
+		valueWidth  = 80
+		barHeight   = 24
+		barSpacing  = 8
+		titleHeight = 40
+	)
+	height := titleHeight + len(bars)*(barHeight+barSpacing) + barSpacing
+
+	var maxTPS float64
+	for _, bar := range bars {
+		if bar.TokensPerSecond > maxTPS {
+			maxTPS = bar.TokensPerSecond
+		}
+	}
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"sans-serif\" font-size=\"13\">\n", width, height)
+	fmt.Fprintf(&svg, "  <rect width=\"100%%\" height=\"100%%\" fill=\"#ffffff\"/>\n")
+	fmt.Fprintf(&svg, "  <text x=\"%d\" y=\"26\" font-size=\"16\" font-weight=\"bold\">%s</text>\n", barSpacing, html.EscapeString(title))
+
+	for i, bar := range bars {
+		y := titleHeight + i*(barHeight+barSpacing)
+		barWidth := 0.0
+		if maxTPS > 0 {
+			barWidth = bar.TokensPerSecond / maxTPS * (width - labelWidth - valueWidth)
+		}
+		fmt.Fprintf(&svg, "  <text x=\"%d\" y=\"%d\" text-anchor=\"end\">%s</text>\n", labelWidth-barSpacing, y+barHeight-7, html.EscapeString(bar.Label))
+		fmt.Fprintf(&svg, "  <rect x=\"%d\" y=\"%d\" width=\"%.1f\" height=\"%d\" fill=\"#4f7cff\"/>\n", labelWidth, y, barWidth, barHeight)
+		fmt.Fprintf(&svg, "  <text x=\"%.1f\" y=\"%d\">%.2f</text>\n", float64(labelWidth)+barWidth+float64(barSpacing), y+barHeight-7, bar.TokensPerSecond)
+	}
+
+	svg.WriteString("</svg>\n")
+	return svg.String()
+}
+
+// RunRequest is the body of a serve mode POST /run request
+type RunRequest struct {
+	Model      string `json:"model"`
+	Iterations int    `json:"iterations"`
+}
+
+// runServe implements "ollamark serve": an HTTP API that benchmarks this machine on
+// request, so a central controller can drive benchmarks across a fleet
+func runServe(args []string) {
+	serveFlags := flag.NewFlagSet("serve", flag.ExitOnError)
+	listenPtr := serveFlags.String("listen", ":8080", "Address to listen on")
+	tokenPtr := serveFlags.String("token", os.Getenv("OLLAMARK_SERVE_TOKEN"), "Shared token required as \"Authorization: Bearer <token>\" (default $OLLAMARK_SERVE_TOKEN)")
+	ollamaPtr := serveFlags.String("o", "http://localhost:11434", "Ollama API endpoint")
+	serveFlags.Parse(args)
+
+	if *tokenPtr == "" {
+		fmt.Fprintln(os.Stderr, "Error: a shared token is required, set -token or OLLAMARK_SERVE_TOKEN")
+		os.Exit(1)
+	}
+	apiEndpoint = *ollamaPtr
+	useLocalModels(apiEndpoint)
+
+	// Benchmarks running concurrently would skew each other
+	var running sync.Mutex
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !hmac.Equal([]byte(token), []byte(*tokenPtr)) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var runRequest RunRequest
+		if err := json.NewDecoder(r.Body).Decode(&runRequest); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if runRequest.Model == "" {
+			http.Error(w, "model must not be empty", http.StatusBadRequest)
+			return
+		}
+		if runRequest.Iterations == 0 {
+			runRequest.Iterations = 2
+		}
+		if runRequest.Iterations < 2 || runRequest.Iterations > 20 {
+			http.Error(w, fmt.Sprintf("iterations must be between 2 and 20, got %d", runRequest.Iterations), http.StatusBadRequest)
+			return
+		}
+
+		if !running.TryLock() {
+			http.Error(w, "a benchmark is already running", http.StatusConflict)
+			return
+		}
+		defer running.Unlock()
+
+		fmt.Printf("Running benchmark of %s with %d iterations\n", runRequest.Model, runRequest.Iterations)
+		benchmarkResult, err := runBenchmark(r.Context(), BenchmarkOptions{
+			ModelName:    runRequest.Model,
+			OllamaAPI:    apiEndpoint,
+			Iterations:   runRequest.Iterations,
+			WarmupPrompt: defaultWarmupPrompt,
+		}, os.Stdout)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(benchmarkResult)
+	})
+
+	fmt.Printf("Ollamark listening on %s\n", *listenPtr)
+	if err := http.ListenAndServe(*listenPtr, mux); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// generate sends a generate request to Ollama and decodes the streamed or single
+// JSON response, returning the final response object and the generated text
+func generate(ctx context.Context, ollamaAPI string, request OllamaRequest) (OllamaResponse, string, error) {
+	resp, err := ollamaPostContext(ctx, ollamaAPI+request.path(), request.body())
+	if err != nil {
+		return OllamaResponse{}, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return OllamaResponse{}, "", fmt.Errorf("generate failed: %s", body)
+	}
+
+	response, responseText, err := decodeGenerateStream(resp.Body, nil)
+	if ctx.Err() != nil {
+		// A stream cut short by cancellation isn't a usable partial result
+		return OllamaResponse{}, "", ctx.Err()
+	}
+	return response, responseText, err
+}
+
+// decodeGenerateStream reads a /api/generate or /api/chat response, calling onChunk after each
+// object with the chunks so far and the time since the first one. If the stream breaks off
+// after tokens arrived, the final metrics are estimated from the chunks received (one token
+// each) and the time they took, and the response is marked Partial instead of failing the
+// generation.
+func decodeGenerateStream(body io.Reader, onChunk func(chunks int, elapsed time.Duration)) (OllamaResponse, string, error) {
+	var response OllamaResponse
+	var responseText string
+	var chunks int
+	var firstChunk, lastChunk time.Time
+
+	decoder := json.NewDecoder(debugBody(body))
+	for {
+		var chunk OllamaResponse
+		err := decoder.Decode(&chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A timed out generation is aborted, not estimated
+			var timeoutErr requestTimeoutError
+			if chunks < 2 || errors.As(err, &timeoutErr) {
+				return OllamaResponse{}, "", err
+			}
+			break
+		}
+
+		now := time.Now()
+		if chunks == 0 {
+			firstChunk = now
+		}
+		lastChunk = now
+		chunks++
+
+		response = chunk
+		responseText += chunk.Response
+		if chunk.Message != nil {
+			responseText += chunk.Message.Content
+		}
+		if onChunk != nil {
+			onChunk(chunks, now.Sub(firstChunk))
+		}
+	}
+
+	if !response.Done {
+		if chunks < 2 {
+			return OllamaResponse{}, "", io.ErrUnexpectedEOF
+		}
+		response.EvalCount = chunks - 1
+		response.EvalDuration = lastChunk.Sub(firstChunk).Nanoseconds()
+		response.Partial = true
+	}
+
+	return response, responseText, nil
+}
+
+// loadPromptSet reads one prompt per non-empty line of path and returns the prompts
+// with the hex SHA-256 of the set, so results are only compared across identical sets
+func loadPromptSet(path string) ([]string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read prompt set: %v", err)
+	}
+
+	var prompts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if prompt := strings.TrimSpace(line); prompt != "" {
+			prompts = append(prompts, prompt)
+		}
+	}
+	if len(prompts) == 0 {
+		return nil, "", fmt.Errorf("prompt set %s contains no prompts", path)
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(prompts, "\n")))
+	return prompts, hex.EncodeToString(hash[:]), nil
+}
+
+// generationTotals accumulates the eval metrics of several generations
+type generationTotals struct {
+	evalCount    int
+	evalDuration float64 // seconds
+	generations  int
+	degraded     bool // some metrics are estimated from a broken-off stream
+	suspicious   bool // some token counts don't match the generated text
+	timings      ollamaTimings
+}
+
+func (t *generationTotals) add(response OllamaResponse, text string) {
+	t.evalCount += response.EvalCount
+	t.evalDuration += float64(response.EvalDuration) / 1e9
+	t.generations++
+	t.degraded = t.degraded || response.Partial
+	t.suspicious = t.suspicious || !tokenCountConsistent(response.EvalCount, text)
+	t.timings.add(response)
+}
+
+// ollamaTimings sums the prompt processing and overall timing Ollama reports per generation
+type ollamaTimings struct {
+	promptEvalCount    int
+	promptEvalDuration int64 // nanoseconds, like the durations below
+	loadDuration       int64
+	totalDuration      int64
+}
+
+func (t *ollamaTimings) add(response OllamaResponse) {
+	t.promptEvalCount += response.PromptEvalCount
+	t.promptEvalDuration += response.PromptEvalDuration
+	t.loadDuration += response.LoadDuration
+	t.totalDuration += response.TotalDuration
+}
+
+// promptTokensPerSecond is the prompt processing speed, 0 if Ollama reported no prompt eval time
+func (t *ollamaTimings) promptTokensPerSecond() float64 {
+	if t.promptEvalDuration == 0 {
+		return 0
+	}
+	return float64(t.promptEvalCount) / (float64(t.promptEvalDuration) / 1e9)
+}
+
+// apply copies the timings to the benchmark result
+func (t *ollamaTimings) apply(benchmarkResult *BenchmarkResult) {
+	benchmarkResult.PromptEvalCount = t.promptEvalCount
+	benchmarkResult.PromptEvalDuration = t.promptEvalDuration
+	benchmarkResult.LoadDuration = t.loadDuration
+	benchmarkResult.OllamaTotalDuration = t.totalDuration
+	benchmarkResult.PromptTokensPerSecond = t.promptTokensPerSecond()
+}
+
+// tokenCountConsistent reports whether evalCount is plausible for the generated text, at
+// roughly 4 characters per token. A wildly different count, like tokens without any text,
+// points at a broken or tampered response.
+func tokenCountConsistent(evalCount int, text string) bool {
+	chars := utf8.RuneCountInString(text)
+	if evalCount > 0 && chars == 0 {
+		return false
+	}
+	estimated := math.Max(float64(chars)/4, 1)
+	ratio := float64(evalCount) / estimated
+	return ratio >= 0.2 && ratio <= 5
+}
+
+// tokensPerSecond is the total tokens divided by the total eval time
+func (t *generationTotals) tokensPerSecond() float64 {
+	if t.evalDuration == 0 {
+		return 0
+	}
+	return float64(t.evalCount) / t.evalDuration
+}
+
+// benchmarkPromptSet generates promptSetNumPredict tokens for each prompt, with the model,
+// options and format of base. Weighing by tokens keeps prompts that happen to ramble from
+// skewing the average. On error, the totals of the prompts completed so far are returned
+// along with it.
+func benchmarkPromptSet(ctx context.Context, ollamaAPI string, base OllamaRequest, prompts []string) (generationTotals, error) {
+	request := base
+	request.Options = map[string]interface{}{"num_predict": promptSetNumPredict}
+	for key, value := range base.Options {
+		request.Options[key] = value
+	}
+
+	var totals generationTotals
+	for i, prompt := range prompts {
+		request.Prompt = prompt
+		response, text, err := generate(ctx, ollamaAPI, request)
+		if err != nil {
+			return totals, fmt.Errorf("prompt %d: %v", i+1, err)
+		}
+		totals.add(response, text)
+	}
+	if totals.evalDuration == 0 {
+		return totals, fmt.Errorf("Ollama reported no eval time for the prompt set")
+	}
+	return totals, nil
+}
+
+// benchmarkTotalTokens generates the prompt of base until at least totalTokens tokens were
+// generated. On error, the totals of the generations completed so far are returned along
+// with it.
+func benchmarkTotalTokens(ctx context.Context, ollamaAPI string, base OllamaRequest, totalTokens int, out io.Writer) (generationTotals, error) {
+	var totals generationTotals
+	for totals.evalCount < totalTokens {
+		response, text, err := generate(ctx, ollamaAPI, base)
+		if err != nil {
+			return totals, err
+		}
+		if response.EvalCount == 0 {
+			return totals, fmt.Errorf("Ollama reported no generated tokens")
+		}
+		totals.add(response, text)
+		fmt.Fprintf(out, "Generated %d/%d tokens\n", totals.evalCount, totalTokens)
+	}
+	return totals, nil
+}
+
+// benchmarkDuration generates the prompt of base back to back until duration has passed.
+// The generation still running at the end of the window is stopped and not counted. Along
+// with the totals, it returns the tokens per second of every completed generation.
+func benchmarkDuration(ctx context.Context, ollamaAPI string, base OllamaRequest, duration time.Duration, out io.Writer) (generationTotals, []float64, error) {
+	windowCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	start := time.Now()
+	var totals generationTotals
+	var trend []float64
+	for {
+		response, text, err := generate(windowCtx, ollamaAPI, base)
+		if windowCtx.Err() != nil {
+			break
+		}
+		if err != nil {
+			return totals, trend, err
+		}
+		if response.EvalCount == 0 || response.EvalDuration == 0 {
+			return totals, trend, fmt.Errorf("Ollama reported no generated tokens")
+		}
+		totals.add(response, text)
+		tokensPerSecond := float64(response.EvalCount) / (float64(response.EvalDuration) / 1e9)
+		trend = append(trend, tokensPerSecond)
+		fmt.Fprintf(out, "Generation %d done after %s: %.*f tokens per second\n", len(trend), time.Since(start).Round(time.Second), tpsPrecision, tokensPerSecond)
+	}
+
+	if totals.generations == 0 && ctx.Err() == nil {
+		return totals, trend, fmt.Errorf("no generation completed within %s, use a longer -duration", duration)
+	}
+	return totals, trend, nil
+}
+
+// tpsSpread returns the minimum, maximum and population standard deviation of values
+func tpsSpread(values []float64) (minTPS, maxTPS, stdDev float64) {
+	if len(values) == 0 {
+		return 0, 0, 0
+	}
+	minTPS, maxTPS = values[0], values[0]
+	var sum float64
+	for _, v := range values {
+		minTPS = math.Min(minTPS, v)
+		maxTPS = math.Max(maxTPS, v)
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return minTPS, maxTPS, math.Sqrt(variance / float64(len(values)))
+}
+
+// tpsTrendPercent returns the change in percent of the average tokens per second from
+// the first to the second half of the values
+func tpsTrendPercent(values []float64) float64 {
+	half := len(values) / 2
+	var first, second float64
+	for _, value := range values[:half] {
+		first += value
+	}
+	for _, value := range values[len(values)-half:] {
+		second += value
+	}
+	if first == 0 {
+		return 0
+	}
+	return (second - first) / first * 100
+}
+
+// compareStreaming runs the request with and without streaming and compares the
+// client-observed tokens per second, which includes the per-chunk overhead
+func compareStreaming(ctx context.Context, ollamaAPI string, base OllamaRequest, iterations int) (*StreamComparison, error) {
+	measure := func(stream bool) (float64, error) {
+		request := base
+		request.Stream = &stream
+
+		var total float64
+		for i := 0; i < iterations; i++ {
+			start := time.Now()
+			response, _, err := generate(ctx, ollamaAPI, request)
+			if err != nil {
+				return 0, err
+			}
+			total += float64(response.EvalCount) / time.Since(start).Seconds()
+		}
+		return total / float64(iterations), nil
+	}
+
+	streamTPS, err := measure(true)
+	if err != nil {
+		return nil, err
+	}
+	nonStreamTPS, err := measure(false)
+	if err != nil {
+		return nil, err
 	}
+
+	return &StreamComparison{
+		StreamTokensPerSecond:    streamTPS,
+		NonStreamTokensPerSecond: nonStreamTPS,
+		OverheadPercent:          (nonStreamTPS - streamTPS) / nonStreamTPS * 100,
+	}, nil
+}
+
+// Lifetime of the submission JWT when the server doesn't send the challenge expiry,
+// the proof-of-work TTL of servers predating expires_at
+const defaultJWTTTL = 60 * time.Second
+
+// jwtExpiry returns the exp claim of the submission JWT, the expiry of the challenge it
+// carries the solution of, so the token lives as long as the server accepts the solution
+func jwtExpiry(challenge ProofOfWorkChallenge) int64 {
+	if challenge.ExpiresAt > 0 {
+		return challenge.ExpiresAt
+	}
+	return time.Now().Add(defaultJWTTTL).Unix()
 }
 
-func generateJWT(nonce string) (string, error) {
+func generateJWT(nonce string, expiresAt int64) (string, error) {
 	secretKey := os.Getenv("KEY")
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	token := jwt.NewWithClaims(jwtSigningMethod(), jwt.MapClaims{
 		"iat":   time.Now().Unix(),
-		"exp":   time.Now().Add(time.Minute * 1).Unix(), // Token expires in 1 minute
+		"exp":   expiresAt,
 		"nonce": nonce,
 	})
 
@@ -1264,8 +4894,30 @@ func generateJWT(nonce string) (string, error) {
 	return tokenString, nil
 }
 
+// submitEnvVars are the environment variables a submission needs, KEY signs the
+// request and PUBLIC_KEY encrypts the payload
+var submitEnvVars = []string{"KEY", "PUBLIC_KEY"}
+
+// checkSubmitEnv reports every missing submission variable in one error instead
+// of failing later with a crypto error or an empty signature
+func checkSubmitEnv() error {
+	var missing []string
+	for _, name := range submitEnvVars {
+		if strings.TrimSpace(os.Getenv(name)) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("cannot submit benchmark, missing environment variables: %s (set them in .env, see .env.example)", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
 func submitBenchmark(benchmarkResult *BenchmarkResult) error {
-	apiEndpoint := os.Getenv("OLLAMARK_API")
+	if err := checkSubmitEnv(); err != nil {
+		return err
+	}
+	apiEndpoint := ollamarkAPI()
 	secretKey := os.Getenv("KEY")
 	publicKey, err := LoadPublicKey()
 	if err != nil {
@@ -1278,24 +4930,72 @@ func submitBenchmark(benchmarkResult *BenchmarkResult) error {
 		return fmt.Errorf("error generating AES key: %v", err)
 	}
 
-	var submissionID = generateUUID()
+	// Network errors and 5xx responses are retried with a fresh proof-of-work, 4xx responses
+	// mean a bad signature or replay and are final. The submission ID stays the same, so an
+	// attempt the server stored although its response was lost isn't submitted twice.
+	submissionID := generateUUID()
+	for attempt := 0; ; attempt++ {
+		retryable, err := submitBenchmarkAttempt(apiEndpoint, secretKey, submissionID, publicKey, aesKey, benchmarkResult)
+		if err == nil {
+			break
+		}
+		if attempt > 0 && submittedBefore(err) {
+			fmt.Fprintln(messages, "An earlier attempt was stored, its response was lost.")
+			break
+		}
+		if !retryable || attempt >= len(submitRetryBackoff) {
+			return err
+		}
+		fmt.Fprintf(messages, "Submission failed (%v), retrying in %s...\n", err, submitRetryBackoff[attempt])
+		time.Sleep(submitRetryBackoff[attempt])
+	}
+
+	fmt.Fprintf(messages, "Benchmark submitted successfully! View it at: https://ollamark.com/marks/%s\n", submissionID)
+	return nil
+}
 
-	// Generate JWT token
-	jwtToken, err := generateJWT(submissionID)
-	if err != nil {
-		return fmt.Errorf("error generating JWT token: %v", err)
+// submitRetryBackoff is the wait before each retry of a failed submission
+var submitRetryBackoff = []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+
+// submittedBefore reports whether err is the server rejecting a submission ID it already stored
+func submittedBefore(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.Code == "replay_detected" || apiErr.Code == "duplicate_submission")
+}
+
+// submitBenchmarkAttempt solves a fresh proof-of-work challenge and sends the benchmark
+// once as submissionID, reporting whether a failure is worth retrying
+func submitBenchmarkAttempt(apiEndpoint, secretKey, submissionID string, publicKey *rsa.PublicKey, aesKey []byte, benchmarkResult *BenchmarkResult) (bool, error) {
+
+	// Tell the user what to expect, the solve can take a while under load
+	if difficulty, err := requestProofOfWorkDifficulty(apiEndpoint); err == nil {
+		fmt.Fprintf(messages, "Solving proof-of-work at difficulty %d, this may take about %s...\n", difficulty, estimateProofOfWorkTime(difficulty).Round(time.Second))
 	}
 
 	// Request proof-of-work challenge
 	challenge, err := requestProofOfWorkChallenge(apiEndpoint)
 	if err != nil {
-		return fmt.Errorf("error requesting proof-of-work challenge: %v", err)
+		return false, fmt.Errorf("error requesting proof-of-work challenge: %v", err)
+	}
+
+	// Solve proof-of-work challenge, with a spinner on the same line
+	spinner := `|/-\`
+	frames := 0
+	powNonce, err := solveProofOfWork(challenge, func(attempts int, elapsed time.Duration) {
+		fmt.Fprintf(messages, "\r%c %s", spinner[frames%len(spinner)], powProgressText(attempts, elapsed))
+		frames++
+	})
+	if frames > 0 {
+		fmt.Fprintln(messages)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error solving proof-of-work challenge: %v", err)
 	}
 
-	// Solve proof-of-work challenge
-	powNonce, err := solveProofOfWork(challenge)
+	// Generate JWT token once solved, so a long solve doesn't use up its lifetime
+	jwtToken, err := generateJWT(submissionID, jwtExpiry(challenge))
 	if err != nil {
-		return fmt.Errorf("error solving proof-of-work challenge: %v", err)
+		return false, fmt.Errorf("error generating JWT token: %v", err)
 	}
 
 	// Include proof-of-work solution in the benchmark result
@@ -1304,19 +5004,20 @@ func submitBenchmark(benchmarkResult *BenchmarkResult) error {
 		Nonce:      powNonce,
 		Timestamp:  challenge.Timestamp,
 		Difficulty: challenge.Difficulty,
+		Signature:  challenge.Signature,
 	}
 
 	// Encrypt benchmark result with AES key
 	jsonData, _ := json.Marshal(benchmarkResult)
 	nonce, encryptedData, err := encryptAESGCM(aesKey, jsonData)
 	if err != nil {
-		return fmt.Errorf("error encrypting data with AES: %v", err)
+		return false, fmt.Errorf("error encrypting data with AES: %v", err)
 	}
 
 	// Encrypt AES key with RSA public key
 	encryptedAESKey, err := encryptRSA(publicKey, aesKey)
 	if err != nil {
-		return fmt.Errorf("error encrypting AES key: %v", err)
+		return false, fmt.Errorf("error encrypting AES key: %v", err)
 	}
 
 	// Prepare payload
@@ -1334,25 +5035,110 @@ func submitBenchmark(benchmarkResult *BenchmarkResult) error {
 	// Create and send the request
 	req, err := http.NewRequest("POST", apiEndpoint+"/api/submit-benchmark", bytes.NewBuffer(payloadBytes))
 	if err != nil {
-		return fmt.Errorf("error submitting benchmark! %v", err)
+		return false, fmt.Errorf("error submitting benchmark! %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+jwtToken)
 	req.Header.Set("X-Submission-ID", submissionID)
 	req.Header.Set("X-Signature", signature)
+	req.Header.Set("User-Agent", userAgent())
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("error submitting benchmark: %v", err)
+		return true, fmt.Errorf("error submitting benchmark: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode >= 500, parseAPIError(resp)
+	}
+	return false, nil
+}
+
+// APIError is the error envelope returned by the Ollamark server
+type APIError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server responded with status %d: %s (%s)", e.StatusCode, e.Message, e.Code)
+}
+
+// parseAPIError decodes the error envelope of a failed Ollamark server response
+func parseAPIError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+
+	var envelope struct {
+		Error *APIError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error == nil {
 		return fmt.Errorf("server responded with status %d: %s", resp.StatusCode, body)
 	}
 
-	fmt.Printf("Benchmark submitted successfully! View it at: https://ollamark.com/marks/%s\n", submissionID)
+	envelope.Error.StatusCode = resp.StatusCode
+	return envelope.Error
+}
+
+// uploadBenchmarkS3 stores the benchmark result as JSON in an S3 bucket, OLLAMARK_S3_BUCKET.
+// OLLAMARK_S3_ENDPOINT points it at an S3-compatible service instead of AWS, e.g.
+// http://localhost:9000 for MinIO, and OLLAMARK_S3_REGION sets the region (default
+// us-east-1). Credentials come from the standard AWS chain: AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY, a shared profile, SSO or an instance role.
+func uploadBenchmarkS3(benchmarkResult *BenchmarkResult) error {
+	endpoint := strings.TrimSuffix(os.Getenv("OLLAMARK_S3_ENDPOINT"), "/")
+	bucket := os.Getenv("OLLAMARK_S3_BUCKET")
+	region := os.Getenv("OLLAMARK_S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	if bucket == "" {
+		return fmt.Errorf("S3 upload requires OLLAMARK_S3_BUCKET")
+	}
+
+	body, err := json.Marshal(benchmarkResult)
+	if err != nil {
+		return fmt.Errorf("error marshaling benchmark result: %v", err)
+	}
+
+	ctx := context.Background()
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	if err != nil {
+		return fmt.Errorf("error loading AWS configuration: %v", err)
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			// S3-compatible services rarely resolve bucket subdomains
+			o.UsePathStyle = true
+		}
+	})
+
+	key := s3ObjectKey(benchmarkResult)
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading benchmark to S3: %v", err)
+	}
+
+	fmt.Fprintf(messages, "Benchmark uploaded to s3://%s/%s\n", bucket, key)
 	return nil
 }
+
+// s3ObjectKey returns "<machine id>/<timestamp>-<model>.json", grouping objects by machine
+// so a bucket listing mirrors the machine history. Results without a machine ID go under
+// "unknown" rather than an empty path segment.
+func s3ObjectKey(benchmarkResult *BenchmarkResult) string {
+	machineID := benchmarkResult.MachineID
+	if machineID == "" {
+		machineID = "unknown"
+	}
+	modelName := strings.NewReplacer(":", "-", "/", "-").Replace(benchmarkResult.ModelName)
+	return fmt.Sprintf("%s/%d-%s.json", machineID, benchmarkResult.Timestamp, modelName)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNvidiaSMIOutput(t *testing.T) {
+	rtx4090 := GPUInfo{Name: "NVIDIA GeForce RTX 4090", Vendor: "NVIDIA", Memory: "24564 MiB", DriverVersion: "550.54.14", Count: 1}
+	rtx3090 := GPUInfo{Name: "NVIDIA GeForce RTX 3090", Vendor: "NVIDIA", Memory: "24576 MiB", DriverVersion: "550.54.14", Count: 1}
+
+	tests := []struct {
+		name    string
+		output  string
+		want    *GPUInfo
+		wantErr bool
+	}{
+		{
+			name:   "two fields",
+			output: "NVIDIA GeForce GTX 1080, 8192 MiB\n",
+			want:   &GPUInfo{Name: "NVIDIA GeForce GTX 1080", Vendor: "NVIDIA", Memory: "8192 MiB", DriverVersion: "Unknown", Count: 1},
+		},
+		{
+			name:   "three fields",
+			output: "NVIDIA GeForce RTX 4090, 24564 MiB, 550.54.14\n",
+			want:   &rtx4090,
+		},
+		{
+			name:   "empty driver version",
+			output: "NVIDIA GeForce GTX 1080, 8192 MiB, \n",
+			want:   &GPUInfo{Name: "NVIDIA GeForce GTX 1080", Vendor: "NVIDIA", Memory: "8192 MiB", DriverVersion: "Unknown", Count: 1},
+		},
+		{
+			name:   "identical GPUs",
+			output: "NVIDIA GeForce RTX 4090, 24564 MiB, 550.54.14\nNVIDIA GeForce RTX 4090, 24564 MiB, 550.54.14\n",
+			want: &GPUInfo{
+				Name: "NVIDIA GeForce RTX 4090", Vendor: "NVIDIA", Memory: "49128 MiB", DriverVersion: "550.54.14", Count: 2,
+				Devices: []GPUInfo{rtx4090, rtx4090},
+			},
+		},
+		{
+			name:   "mixed GPUs",
+			output: "NVIDIA GeForce RTX 4090, 24564 MiB, 550.54.14\nNVIDIA GeForce RTX 3090, 24576 MiB, 550.54.14\n",
+			want: &GPUInfo{
+				Name: "NVIDIA GeForce RTX 4090 + NVIDIA GeForce RTX 3090", Vendor: "NVIDIA", Memory: "49140 MiB", DriverVersion: "550.54.14", Count: 2,
+				Devices: []GPUInfo{rtx4090, rtx3090},
+			},
+		},
+		{
+			name:   "blank lines",
+			output: "\nNVIDIA GeForce RTX 4090, 24564 MiB, 550.54.14\n\n  \nNVIDIA GeForce RTX 3090, 24576 MiB, 550.54.14\n\n",
+			want: &GPUInfo{
+				Name: "NVIDIA GeForce RTX 4090 + NVIDIA GeForce RTX 3090", Vendor: "NVIDIA", Memory: "49140 MiB", DriverVersion: "550.54.14", Count: 2,
+				Devices: []GPUInfo{rtx4090, rtx3090},
+			},
+		},
+		{
+			name:    "empty output",
+			output:  "\n",
+			wantErr: true,
+		},
+		{
+			name:    "malformed line",
+			output:  "NVIDIA GeForce RTX 4090, 24564 MiB, 550.54.14\nNo devices were found\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNvidiaSMIOutput(tt.output)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWMICCPUName(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"intel", "Name                                      \r\nIntel(R) Core(TM) i7-9700K CPU @ 3.60GHz  \r\n\r\n", "Intel(R) Core(TM) i7-9700K CPU @ 3.60GHz"},
+		{"amd", "Name\r\nAMD Ryzen 7 5800X 8-Core Processor\r\n", "AMD Ryzen 7 5800X 8-Core Processor"},
+		{"header only", "Name\r\n\r\n", "Unknown"},
+		{"empty", "", "Unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseWMICCPUName(tt.output); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLshwCPUName(t *testing.T) {
+	const ryzen = `WARNING: you should run this program as super-user.
+  *-cpu
+       description: CPU
+       product: AMD Ryzen 9 5950X 16-Core Processor
+       vendor: Advanced Micro Devices [AMD]
+       physical id: f
+       bus info: cpu@0
+       version: 25.33.0
+       size: 3400MHz
+       capacity: 4950MHz
+       width: 64 bits
+`
+	const dualSocket = `  *-cpu:0
+       description: CPU
+       product: Intel(R) Xeon(R) Gold 6230 CPU @ 2.10GHz
+       vendor: Intel Corp.
+       slot: CPU1
+  *-cpu:1
+       description: CPU
+       product: Intel(R) Xeon(R) Gold 6230 CPU @ 2.10GHz
+       vendor: Intel Corp.
+       slot: CPU2
+`
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"single cpu", ryzen, "AMD Ryzen 9 5950X 16-Core Processor"},
+		{"dual socket", dualSocket, "Intel(R) Xeon(R) Gold 6230 CPU @ 2.10GHz"},
+		{"no product", "  *-cpu\n       description: CPU\n", "Unknown"},
+		{"empty", "", "Unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseLshwCPUName(tt.output); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCPUInfoModelName(t *testing.T) {
+	const intel = "processor\t: 0\nvendor_id\t: GenuineIntel\ncpu family\t: 6\nmodel\t\t: 158\n" +
+		"model name\t: Intel(R) Core(TM) i7-8700 CPU @ 3.20GHz\nstepping\t: 10\n\n" +
+		"processor\t: 1\nvendor_id\t: GenuineIntel\ncpu family\t: 6\nmodel\t\t: 158\n" +
+		"model name\t: Intel(R) Core(TM) i7-8700 CPU @ 3.20GHz\nstepping\t: 10\n"
+	// Raspberry Pi kernels don't report a model name per processor
+	const arm = "processor\t: 0\nBogoMIPS\t: 108.00\nFeatures\t: fp asimd evtstrm crc32 cpuid\n" +
+		"CPU implementer\t: 0x41\nCPU part\t: 0xd08\n\nHardware\t: BCM2835\nModel\t\t: Raspberry Pi 4 Model B Rev 1.4\n"
+
+	tests := []struct {
+		name    string
+		cpuinfo string
+		want    string
+	}{
+		{"intel", intel, "Intel(R) Core(TM) i7-8700 CPU @ 3.20GHz"},
+		{"no model name", arm, "Unknown"},
+		{"empty", "", "Unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseCPUInfoModelName(tt.cpuinfo); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// powHashValid is the hash check of the server's VerifyProofOfWork
+func powHashValid(challenge ProofOfWorkChallenge, nonce string) bool {
+	hash := sha256.Sum256([]byte(challenge.Challenge + nonce))
+	return strings.HasPrefix(hex.EncodeToString(hash[:]), strings.Repeat("0", challenge.Difficulty))
+}
+
+func TestSolveProofOfWork(t *testing.T) {
+	for difficulty := 1; difficulty <= 4; difficulty++ {
+		challenge := ProofOfWorkChallenge{
+			Challenge:  fmt.Sprintf("challenge-%d", difficulty),
+			Difficulty: difficulty,
+			Timestamp:  time.Now().Unix(),
+		}
+		nonce, err := solveProofOfWork(challenge, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !powHashValid(challenge, nonce) {
+			t.Errorf("difficulty %d: nonce %q doesn't solve %q", difficulty, nonce, challenge.Challenge)
+		}
+	}
+}
+
+func BenchmarkSolveProofOfWork(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		challenge := ProofOfWorkChallenge{Challenge: fmt.Sprintf("benchmark-%d", i), Difficulty: 5}
+		if _, err := solveProofOfWork(challenge, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestS3ObjectKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		result BenchmarkResult
+		want   string
+	}{
+		{"tagged model", BenchmarkResult{MachineID: "abc123", Timestamp: 1700000000, ModelName: "llama3:8b"}, "abc123/1700000000-llama3-8b.json"},
+		{"namespaced model", BenchmarkResult{MachineID: "abc123", Timestamp: 1700000000, ModelName: "library/phi3:mini"}, "abc123/1700000000-library-phi3-mini.json"},
+		{"no machine id", BenchmarkResult{Timestamp: 1700000000, ModelName: "llama3"}, "unknown/1700000000-llama3.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s3ObjectKey(&tt.result); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
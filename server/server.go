@@ -6,6 +6,7 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"crypto"
 	"crypto/aes"
@@ -14,19 +15,27 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -37,26 +46,90 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type BenchmarkResult struct {
-	ModelName       string              `json:"model_name"`
-	Timestamp       int64               `json:"timestamp"`
-	Duration        float64             `json:"duration"`
-	TokensPerSecond float64             `json:"tokens_per_second"`
-	EvalCount       int                 `json:"eval_count"`
-	EvalDuration    int64               `json:"eval_duration"`
-	Iterations      int                 `json:"iterations"`
-	SysInfo         *SysInfo            `json:"sys_info"`
-	GPUInfo         *GPUInfo            `json:"gpu_info"`
-	OllamaVersion   string              `json:"ollama_version"`
-	ClientType      string              `json:"client_type"`
-	ClientVersion   string              `json:"client_version"`
-	SubmissionID    string              `json:"submission_id"`
-	IP              string              `json:"ip"`
-	ProofOfWork     ProofOfWorkSolution `json:"proof_of_work"`
+	ModelName        string              `json:"model_name"`
+	ModelDigest      string              `json:"model_digest"`
+	ModelDetails     *ModelDetails       `json:"model_details"`
+	Prompt           string              `json:"prompt"`
+	PromptHash       string              `json:"prompt_hash"`
+	WarmupPrompt     string              `json:"warmup_prompt"`
+	WarmupPromptHash string              `json:"warmup_prompt_hash"`
+	WarmupRuns       int                 `json:"warmup_runs,omitempty"`
+	PromptSetHash    string              `json:"prompt_set_hash,omitempty"`
+	TotalTokens      int                 `json:"total_tokens,omitempty"`
+	Threads          int                 `json:"threads,omitempty"`
+	NumPredict       int                 `json:"num_predict,omitempty"`
+	Seed             *int                `json:"seed,omitempty"`
+	Temperature      *float64            `json:"temperature,omitempty"`
+	NumCtx           int                 `json:"num_ctx,omitempty"`
+	Format           string              `json:"format,omitempty"`
+	Chat             bool                `json:"chat,omitempty"`
+	DurationTarget   float64             `json:"duration_target,omitempty"`
+	GenerationTPS    []float64           `json:"generation_tps,omitempty"`
+	MinTPS           float64             `json:"min_tps,omitempty"`
+	MaxTPS           float64             `json:"max_tps,omitempty"`
+	StdDev           float64             `json:"stddev,omitempty"`
+	Timestamp        int64               `json:"timestamp"`
+	Duration         float64             `json:"duration"`
+	TokensPerSecond  float64             `json:"tokens_per_second"`
+	EvalCount        int                 `json:"eval_count"`
+	EvalDuration     int64               `json:"eval_duration"`
+	Iterations       int                 `json:"iterations"`
+	SysInfo          *SysInfo            `json:"sys_info"`
+	GPUInfo          *GPUInfo            `json:"gpu_info"`
+	GPUs             []GPUInfo           `json:"gpus,omitempty"`
+	OllamaVersion    string              `json:"ollama_version"`
+	ClientType       string              `json:"client_type"`
+	ClientVersion    string              `json:"client_version"`
+	SubmissionID     string              `json:"submission_id"`
+	IP               string              `json:"ip"`
+	ProofOfWork      ProofOfWorkSolution `json:"proof_of_work"`
+	StreamComparison *StreamComparison   `json:"stream_comparison,omitempty"`
+	MachineID        string              `json:"machine_id"`
+	Environment      string              `json:"environment"`
+	UserAgent        string              `json:"user_agent"`
+	Degraded         bool                `json:"degraded,omitempty"`
+	Suspicious       bool                `json:"suspicious,omitempty"`
+	Tags             []string            `json:"tags,omitempty"`
+
+	// Cancelled results stopped early, only CompletedIterations of Iterations were measured
+	Cancelled           bool `json:"cancelled,omitempty"`
+	CompletedIterations int  `json:"completed_iterations"`
+
+	// TotalDurationSec is the wall time of the measured generations only, SetupDurationSec the time
+	// before them: system info, model pull, digest check and warmup. Duration equals TotalDurationSec.
+	TotalDurationSec float64 `json:"total_duration_sec"`
+	SetupDurationSec float64 `json:"setup_duration_sec"`
+
+	// Timing split reported by Ollama, summed over the measured generations in nanoseconds.
+	// PromptTokensPerSecond is the prompt processing (prefill) speed, TokensPerSecond the generation speed.
+	PromptEvalCount       int     `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration    int64   `json:"prompt_eval_duration,omitempty"`
+	LoadDuration          int64   `json:"load_duration,omitempty"`
+	OllamaTotalDuration   int64   `json:"ollama_total_duration,omitempty"`
+	PromptTokensPerSecond float64 `json:"prompt_tokens_per_second,omitempty"`
+}
+
+// StreamComparison holds the client-observed throughput with and without streaming
+type StreamComparison struct {
+	StreamTokensPerSecond    float64 `json:"stream_tokens_per_second"`
+	NonStreamTokensPerSecond float64 `json:"non_stream_tokens_per_second"`
+	OverheadPercent          float64 `json:"overhead_percent"`
+}
+
+// ModelDetails describes the benchmarked model as reported by the client's Ollama /api/show
+type ModelDetails struct {
+	Format            string `json:"format"`
+	Family            string `json:"family"`
+	ParameterSize     string `json:"parameter_size"`
+	QuantizationLevel string `json:"quantization_level"`
+	ContextLength     int    `json:"context_length"`
+	Template          string `json:"template"`
 }
 
 type SysInfo struct {
@@ -70,11 +143,21 @@ type SysInfo struct {
 }
 
 type GPUInfo struct {
-	Name          string `json:"name"`
-	Vendor        string `json:"vendor"`
-	Memory        string `json:"memory"`
-	DriverVersion string `json:"driver_version"`
-	Count         int    `json:"count"`
+	Name          string    `json:"name"`
+	Vendor        string    `json:"vendor"`
+	Memory        string    `json:"memory"`
+	DriverVersion string    `json:"driver_version"`
+	Count         int       `json:"count"`
+	Usage         *GPUUsage `json:"usage,omitempty"`
+	Devices       []GPUInfo `json:"devices,omitempty"` // Each GPU when Count GPUs are combined, e.g. several NVIDIA GPUs
+}
+
+// GPUUsage holds GPU utilization and clocks averaged over the benchmark generations
+type GPUUsage struct {
+	UtilizationPercent float64 `json:"utilization_percent"`
+	SMClockMHz         float64 `json:"sm_clock_mhz"`
+	MemoryClockMHz     float64 `json:"memory_clock_mhz"`
+	Samples            int     `json:"samples"`
 }
 
 type ModelInfo struct {
@@ -83,7 +166,7 @@ type ModelInfo struct {
 	Quantization string
 }
 
-// Models supported
+// Built-in models supported, used unless MODELS_FILE or MODELS_JSON provides the allowlist
 var MODELS = []ModelInfo{
 	{Name: "llama3", Parameters: "8B", Quantization: "Q4_0"},
 	{Name: "phi3", Parameters: "3B", Quantization: "Q4_K_M"},
@@ -113,6 +196,62 @@ var MODELS = []ModelInfo{
 	{Name: "llama2", Parameters: "7B", Quantization: "Q4_0"},
 }
 
+// Models currently accepted, replaced on reload
+var (
+	activeModels      = MODELS
+	activeModelsMutex sync.RWMutex
+)
+
+// getModels returns the current model allowlist
+func getModels() []ModelInfo {
+	activeModelsMutex.RLock()
+	defer activeModelsMutex.RUnlock()
+	return activeModels
+}
+
+// loadModels reads the model allowlist as a JSON array of models from the file named by
+// MODELS_FILE or from MODELS_JSON, falling back to the built-in MODELS
+func loadModels() ([]ModelInfo, error) {
+	var data []byte
+	if path := os.Getenv("MODELS_FILE"); path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MODELS_FILE: %v", err)
+		}
+		data = fileData
+	} else if value := os.Getenv("MODELS_JSON"); value != "" {
+		data = []byte(value)
+	} else {
+		return MODELS, nil
+	}
+
+	var models []ModelInfo
+	if err := json.Unmarshal(data, &models); err != nil {
+		return nil, fmt.Errorf("invalid model list: %v", err)
+	}
+	if len(models) == 0 {
+		return nil, fmt.Errorf("model list is empty")
+	}
+	for i, model := range models {
+		if model.Name == "" {
+			return nil, fmt.Errorf("model %d has no name", i+1)
+		}
+	}
+	return models, nil
+}
+
+// reloadModels replaces the model allowlist, keeping the current one if loading fails
+func reloadModels() ([]ModelInfo, error) {
+	models, err := loadModels()
+	if err != nil {
+		return nil, err
+	}
+	activeModelsMutex.Lock()
+	activeModels = models
+	activeModelsMutex.Unlock()
+	return models, nil
+}
+
 var cache sync.Map
 
 type CacheItem struct {
@@ -121,13 +260,16 @@ type CacheItem struct {
 	Timestamp time.Time
 }
 
+// mongoConnect opens a MongoDB client, a variable so tests can count the connections
+var mongoConnect = mongo.Connect
+
 func connectDB() (*mongo.Client, error) {
 	mongodblink := os.Getenv("MONGODB")
 	clientOptions := options.Client().ApplyURI(mongodblink)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, clientOptions)
+	client, err := mongoConnect(ctx, clientOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -135,6 +277,44 @@ func connectDB() (*mongo.Client, error) {
 	return client, nil
 }
 
+// pingDB checks that MongoDB is reachable, with a short timeout so readiness probes don't hang
+func pingDB(client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	return client.Ping(ctx, nil)
+}
+
+// ensureQueryIndexes creates the indexes the benchmark queries filter and sort on
+func ensureQueryIndexes(client *mongo.Client) error {
+	collection := client.Database("ollamark_db").Collection("benchmarks")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "modelname", Value: 1}}},
+		{Keys: bson.D{{Key: "tokenspersecond", Value: 1}}},
+		{Keys: bson.D{{Key: "sysinfo.os", Value: 1}}},
+	})
+	return err
+}
+
+// ensureSubmissionIDIndex creates the unique index on submissionid that enforces dedup at
+// the database layer. It is sparse so older documents without a submission ID don't collide,
+// and created on its own since duplicates already stored make it fail.
+func ensureSubmissionIDIndex(client *mongo.Client) error {
+	collection := client.Database("ollamark_db").Collection("benchmarks")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "submissionid", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	})
+	return err
+}
+
 func insertBenchmark(client *mongo.Client, benchmark BenchmarkResult) error {
 	collection := client.Database("ollamark_db").Collection("benchmarks")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -184,15 +364,78 @@ func decryptAESGCM(key, nonce, ciphertext []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
-func verifySignature(submissionID, signature, secretKey string) bool {
-	mac := hmac.New(sha256.New, []byte(secretKey))
-	mac.Write([]byte(submissionID))
-	expectedMAC := mac.Sum(nil)
+// KeyConfig holds the shared secret and the algorithms of the JWTs and HMAC signatures.
+// While a key is rotated, PreviousKey stays valid for verification until PreviousKeyExpires.
+type KeyConfig struct {
+	CurrentKey         string
+	PreviousKey        string
+	PreviousKeyExpires time.Time // Zero to accept PreviousKey until it is removed
+	JWTAlgorithm       string    // HS256, HS384 or HS512
+	HMACHash           func() hash.Hash
+}
+
+var keyConfig KeyConfig
+
+// loadKeyConfig reads KEY, PREVIOUS_KEY, PREVIOUS_KEY_EXPIRES (RFC 3339), JWT_ALGORITHM
+// (HS256, HS384 or HS512, default HS256) and HMAC_ALGORITHM (sha256 or sha512, default sha256)
+func loadKeyConfig() (KeyConfig, error) {
+	config := KeyConfig{
+		CurrentKey:   os.Getenv("KEY"),
+		PreviousKey:  os.Getenv("PREVIOUS_KEY"),
+		JWTAlgorithm: "HS256",
+		HMACHash:     sha256.New,
+	}
+
+	if value := os.Getenv("PREVIOUS_KEY_EXPIRES"); value != "" {
+		expires, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return config, fmt.Errorf("invalid PREVIOUS_KEY_EXPIRES: %q", value)
+		}
+		config.PreviousKeyExpires = expires
+	}
+
+	switch value := os.Getenv("JWT_ALGORITHM"); value {
+	case "":
+	case "HS256", "HS384", "HS512":
+		config.JWTAlgorithm = value
+	default:
+		return config, fmt.Errorf("invalid JWT_ALGORITHM: %q", value)
+	}
+
+	switch value := os.Getenv("HMAC_ALGORITHM"); value {
+	case "", "sha256":
+	case "sha512":
+		config.HMACHash = sha512.New
+	default:
+		return config, fmt.Errorf("invalid HMAC_ALGORITHM: %q", value)
+	}
+
+	return config, nil
+}
+
+// verificationKeys returns the keys accepted for verification, the current key first
+func (k KeyConfig) verificationKeys() []string {
+	keys := []string{k.CurrentKey}
+	if k.PreviousKey != "" && (k.PreviousKeyExpires.IsZero() || time.Now().Before(k.PreviousKeyExpires)) {
+		keys = append(keys, k.PreviousKey)
+	}
+	return keys
+}
+
+// verifySignature checks the HMAC of the submission ID against each accepted key
+func verifySignature(submissionID, signature string) bool {
 	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
 	if err != nil {
 		return false
 	}
-	return hmac.Equal(signatureBytes, expectedMAC)
+	for _, key := range keyConfig.verificationKeys() {
+		mac := hmac.New(keyConfig.HMACHash, []byte(key))
+		mac.Write([]byte(submissionID))
+		if hmac.Equal(signatureBytes, mac.Sum(nil)) {
+			return true
+		}
+	}
+	return false
 }
 
 func checkSubmissionID(client *mongo.Client, submissionID string) (bool, error) {
@@ -208,15 +451,21 @@ func checkSubmissionID(client *mongo.Client, submissionID string) (bool, error)
 	return count == 0, nil
 }
 
-// Function to validate JWT token
+// Function to validate JWT token, signed with any accepted key
 func validateJWT(tokenString string) (jwt.MapClaims, error) {
-	secretKey := os.Getenv("KEY")
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	var token *jwt.Token
+	var err error
+	for _, key := range keyConfig.verificationKeys() {
+		token, err = jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			if token.Method.Alg() != keyConfig.JWTAlgorithm {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(key), nil
+		})
+		if err == nil {
+			break
 		}
-		return []byte(secretKey), nil
-	})
+	}
 	if err != nil {
 		log.Printf("JWT parsing error: %v", err) // Log any JWT parsing errors
 		return nil, err
@@ -231,29 +480,19 @@ func validateJWT(tokenString string) (jwt.MapClaims, error) {
 }
 
 // Middleware to validate JWT token
-func authMiddleware() gin.HandlerFunc {
+func authMiddleware(client *mongo.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString := c.GetHeader("Authorization")
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing Authorization header"})
+			respondError(c, http.StatusUnauthorized, ErrCodeMissingAuthorization, "Missing Authorization header")
 			fmt.Printf("Missing Authorization header: %v", tokenString)
-			c.Abort()
 			return
 		}
 
 		claims, err := validateJWT(strings.TrimPrefix(tokenString, "Bearer "))
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			respondError(c, http.StatusUnauthorized, ErrCodeInvalidToken, err.Error())
 			fmt.Printf("Invalid token: %v", err)
-			c.Abort()
-			return
-		}
-
-		// monogo client
-		client, err := connectDB()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to database"})
-			c.Abort()
 			return
 		}
 
@@ -261,13 +500,13 @@ func authMiddleware() gin.HandlerFunc {
 		nonce := claims["nonce"].(string)
 		isUnique, err := checkSubmissionID(client, nonce)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check submission"})
+			respondError(c, http.StatusInternalServerError, ErrCodeDatabase, "Failed to check submission")
 			fmt.Printf("Failed to check submission ID: %v", err)
 			return
 		}
 
 		if !isUnique {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Replay attack detected"})
+			respondError(c, http.StatusUnauthorized, ErrCodeReplayDetected, "Replay attack detected")
 			return
 		}
 
@@ -276,6 +515,243 @@ func authMiddleware() gin.HandlerFunc {
 	}
 }
 
+// Middleware restricting operator endpoints to requests bearing ADMIN_TOKEN.
+// The endpoints are disabled when ADMIN_TOKEN is not set.
+func adminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminToken := os.Getenv("ADMIN_TOKEN")
+		if adminToken == "" {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "Not found")
+			return
+		}
+
+		tokenString := c.GetHeader("Authorization")
+		if tokenString == "" {
+			respondError(c, http.StatusUnauthorized, ErrCodeMissingAuthorization, "Missing Authorization header")
+			return
+		}
+
+		if !hmac.Equal([]byte(strings.TrimPrefix(tokenString, "Bearer ")), []byte(adminToken)) {
+			respondError(c, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid admin token")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// gzipResponseWriter compresses everything the handlers write. Compression starts with
+// the first non-empty write, so responses without a body don't get a gzip header or frame.
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	writer  *gzip.Writer
+	started bool
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !w.started {
+		status := w.Status()
+		if len(data) == 0 || status == http.StatusNoContent || status == http.StatusNotModified {
+			return w.ResponseWriter.Write(data)
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.writer.Reset(w.ResponseWriter)
+		w.started = true
+	}
+	return w.writer.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Reused gzip writers, allocating one per response is expensive
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+// Middleware applying a tollbooth limiter per IP
+func rateLimitMiddleware(lmt *limiter.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		httpError := tollbooth.LimitByRequest(lmt, c.Writer, c.Request)
+		if httpError != nil {
+			respondError(c, httpError.StatusCode, ErrCodeRateLimited, httpError.Message)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Middleware compressing responses with gzip for clients that send Accept-Encoding: gzip,
+// which shrinks large benchmark lists considerably
+func gzipMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+			c.Next()
+			return
+		}
+
+		gz := gzipWriterPool.Get().(*gzip.Writer)
+		writer := &gzipResponseWriter{ResponseWriter: c.Writer, writer: gz}
+		defer func() {
+			if writer.started {
+				gz.Close()
+			}
+			gzipWriterPool.Put(gz)
+		}()
+
+		c.Header("Vary", "Accept-Encoding")
+		c.Writer = writer
+		c.Next()
+	}
+}
+
+// Machine-readable error codes returned in the error envelope
+const (
+	ErrCodeMissingAuthorization = "missing_authorization"
+	ErrCodeInvalidToken         = "invalid_token"
+	ErrCodeReplayDetected       = "replay_detected"
+	ErrCodeRateLimited          = "rate_limited"
+	ErrCodeIPRateLimited        = "ip_rate_limited"
+	ErrCodeResubmissionLimited  = "resubmission_limited"
+	ErrCodeNotFound             = "not_found"
+	ErrCodeInvalidRequest       = "invalid_request"
+	ErrCodeInvalidSignature     = "invalid_signature"
+	ErrCodeDuplicateSubmission  = "duplicate_submission"
+	ErrCodeDecryptionFailed     = "decryption_failed"
+	ErrCodeInvalidBenchmark     = "invalid_benchmark"
+	ErrCodeInvalidModel         = "invalid_model"
+	ErrCodeInvalidPoW           = "invalid_pow"
+	ErrCodeDatabase             = "database_error"
+	ErrCodeInvalidConfig        = "invalid_config"
+)
+
+// APIError is the body of the error envelope: {"error": {"code": "...", "message": "..."}}
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// respondError writes the error envelope and aborts the remaining handlers
+func respondError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": APIError{Code: code, Message: message}})
+}
+
+// machineFingerprint mirrors the client's fingerprint: the hex SHA-256 of
+// "cpu name|cpu cores|memory|gpu name|gpu memory". It is recomputed from the
+// submitted hardware so results from one machine always group together.
+func machineFingerprint(sysInfo *SysInfo, gpuInfo *GPUInfo) string {
+	var fields []string
+	if sysInfo != nil {
+		fields = append(fields, sysInfo.CPUName, sysInfo.CPU, sysInfo.Memory)
+	} else {
+		fields = append(fields, "", "", "")
+	}
+	if gpuInfo != nil {
+		fields = append(fields, gpuInfo.Name, gpuInfo.Memory)
+	} else {
+		fields = append(fields, "", "")
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(fields, "|")))
+	return hex.EncodeToString(hash[:])
+}
+
+// A machine fingerprint is a hex encoded SHA-256 hash
+var machineIDPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Limits on the free-form tags submitters may attach to a result
+const (
+	maxTags      = 10
+	maxTagLength = 32
+)
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// sanitizeTags lowercases, trims and deduplicates tags, dropping empty ones, and
+// rejects tags that are too many, too long or contain anything but a-z, 0-9, '.', '_' and '-'
+func sanitizeTags(tags []string) ([]string, error) {
+	var sanitized []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("tag %q may only contain a-z, 0-9, '.', '_' and '-'", tag)
+		}
+		seen[tag] = true
+		sanitized = append(sanitized, tag)
+	}
+	if len(sanitized) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	return sanitized, nil
+}
+
+// Range of iterations a submitted benchmark may claim
+const (
+	minIterations = 1
+	maxIterations = 50
+)
+
+// maxGenerations bounds the iterations of -prompt-set, -total-tokens and -duration runs,
+// which report the number of generations instead of a chosen iteration count
+const maxGenerations = 10000
+
+// validateIterations checks that the iteration count is in range for the benchmark mode
+// and agrees with the fields derived from it
+func validateIterations(benchmarkResult BenchmarkResult) error {
+	generationsMode := benchmarkResult.PromptSetHash != "" || benchmarkResult.TotalTokens > 0 || benchmarkResult.DurationTarget > 0
+	if !generationsMode && (benchmarkResult.Iterations < minIterations || benchmarkResult.Iterations > maxIterations) {
+		return fmt.Errorf("iterations must be between %d and %d, got %d", minIterations, maxIterations, benchmarkResult.Iterations)
+	}
+	if generationsMode {
+		if benchmarkResult.Iterations < 1 || benchmarkResult.Iterations > maxGenerations {
+			return fmt.Errorf("generations must be between 1 and %d, got %d", maxGenerations, benchmarkResult.Iterations)
+		}
+		// Every generation produces at least one token
+		if benchmarkResult.Iterations > benchmarkResult.EvalCount {
+			return fmt.Errorf("%d generations can't produce only %d tokens", benchmarkResult.Iterations, benchmarkResult.EvalCount)
+		}
+	}
+	// Duration runs always report each generation, the count is checked against them below
+	if benchmarkResult.DurationTarget > 0 && len(benchmarkResult.GenerationTPS) == 0 {
+		return fmt.Errorf("duration benchmarks must report per-generation results")
+	}
+	// Older clients don't report completed iterations
+	if benchmarkResult.CompletedIterations != 0 && benchmarkResult.CompletedIterations != benchmarkResult.Iterations {
+		return fmt.Errorf("completed iterations (%d) don't match iterations (%d)", benchmarkResult.CompletedIterations, benchmarkResult.Iterations)
+	}
+	if len(benchmarkResult.GenerationTPS) > 0 && len(benchmarkResult.GenerationTPS) != benchmarkResult.Iterations {
+		return fmt.Errorf("%d per-generation results don't match iterations (%d)", len(benchmarkResult.GenerationTPS), benchmarkResult.Iterations)
+	}
+	return nil
+}
+
+// validateTPSSpread checks that the per-iteration spread, if reported, is consistent with
+// the average. Older clients don't report it.
+func validateTPSSpread(benchmarkResult BenchmarkResult) error {
+	if benchmarkResult.MinTPS == 0 && benchmarkResult.MaxTPS == 0 && benchmarkResult.StdDev == 0 {
+		return nil
+	}
+	if benchmarkResult.MinTPS < 0 || benchmarkResult.StdDev < 0 || benchmarkResult.MinTPS > benchmarkResult.MaxTPS {
+		return fmt.Errorf("invalid tokens per second spread: min %f, max %f, stddev %f", benchmarkResult.MinTPS, benchmarkResult.MaxTPS, benchmarkResult.StdDev)
+	}
+	// The standard deviation can't exceed half the range
+	if benchmarkResult.StdDev > (benchmarkResult.MaxTPS-benchmarkResult.MinTPS)/2+1e-6 {
+		return fmt.Errorf("stddev %f doesn't fit the range %f to %f", benchmarkResult.StdDev, benchmarkResult.MinTPS, benchmarkResult.MaxTPS)
+	}
+	return nil
+}
+
 func contains(models []ModelInfo, modelName string) bool {
 	for _, model := range models {
 		if model.Name == modelName {
@@ -287,11 +763,15 @@ func contains(models []ModelInfo, modelName string) bool {
 
 var ipRequests = make(map[string]int)
 var ipLastRequest = make(map[string]time.Time)
+var ipRequestsMutex sync.Mutex
 var requestLimit = 1
 var timeWindow = 1 * time.Second
 
 // checkIP checks if an IP address is spamming and rate limits it
 func checkIP(ip string) bool {
+	ipRequestsMutex.Lock()
+	defer ipRequestsMutex.Unlock()
+
 	now := time.Now()
 	if lastRequest, exists := ipLastRequest[ip]; exists && now.Sub(lastRequest) > timeWindow {
 		ipRequests[ip] = 0
@@ -303,6 +783,115 @@ func checkIP(ip string) bool {
 	return ipRequests[ip] <= requestLimit
 }
 
+// sweepIPRequests forgets IPs whose last request is older than the time window at now,
+// their count would be reset on the next request anyway
+func sweepIPRequests(now time.Time) {
+	ipRequestsMutex.Lock()
+	defer ipRequestsMutex.Unlock()
+	for ip, lastRequest := range ipLastRequest {
+		if now.Sub(lastRequest) > timeWindow {
+			delete(ipRequests, ip)
+			delete(ipLastRequest, ip)
+		}
+	}
+}
+
+// StartIPRequestsCleanup periodically sweeps the per-IP rate limiter maps
+func StartIPRequestsCleanup() {
+	ticker := time.NewTicker(1 * time.Minute)
+	go func() {
+		for {
+			<-ticker.C
+			sweepIPRequests(time.Now())
+		}
+	}()
+}
+
+// Submissions of the same model from the same machine allowed per window, beyond
+// which further submissions are throttled. Configured by RESUBMISSION_LIMIT and
+// RESUBMISSION_WINDOW.
+var resubmissionLimit = 3
+var resubmissionWindow = 10 * time.Minute
+
+var recentSubmissions = make(map[string][]time.Time)
+var recentSubmissionsMutex sync.Mutex
+
+// loadResubmissionConfig reads RESUBMISSION_LIMIT and RESUBMISSION_WINDOW (e.g. "10m"),
+// keeping the defaults for unset variables
+func loadResubmissionConfig() error {
+	if value := os.Getenv("RESUBMISSION_LIMIT"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return fmt.Errorf("invalid RESUBMISSION_LIMIT: %q", value)
+		}
+		resubmissionLimit = limit
+	}
+	if value := os.Getenv("RESUBMISSION_WINDOW"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			return fmt.Errorf("invalid RESUBMISSION_WINDOW: %q", value)
+		}
+		resubmissionWindow = window
+	}
+	return nil
+}
+
+// checkResubmission records a submission of modelName from machineID and reports
+// whether it is within the limit, catching bursts of near-identical results from
+// one machine that each carry a fresh submission ID
+func checkResubmission(machineID, modelName string) bool {
+	recentSubmissionsMutex.Lock()
+	defer recentSubmissionsMutex.Unlock()
+
+	key := machineID + "|" + modelName
+	now := time.Now()
+
+	var recent []time.Time
+	for _, submittedAt := range recentSubmissions[key] {
+		if now.Sub(submittedAt) < resubmissionWindow {
+			recent = append(recent, submittedAt)
+		}
+	}
+
+	if len(recent) >= resubmissionLimit {
+		recentSubmissions[key] = recent
+		return false
+	}
+	recentSubmissions[key] = append(recent, now)
+	return true
+}
+
+// sweepRecentSubmissions drops the submissions that left the window at now and forgets
+// machines and models without any left, which would otherwise stay in the map forever
+func sweepRecentSubmissions(now time.Time) {
+	recentSubmissionsMutex.Lock()
+	defer recentSubmissionsMutex.Unlock()
+	for key, submittedAt := range recentSubmissions {
+		var recent []time.Time
+		for _, t := range submittedAt {
+			if now.Sub(t) < resubmissionWindow {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) == 0 {
+			delete(recentSubmissions, key)
+		} else {
+			recentSubmissions[key] = recent
+		}
+	}
+}
+
+// StartRecentSubmissionsCleanup periodically sweeps the resubmission map
+func StartRecentSubmissionsCleanup() {
+	ticker := time.NewTicker(1 * time.Minute)
+	go func() {
+		for {
+			<-ticker.C
+			sweepRecentSubmissions(time.Now())
+		}
+	}()
+}
+
 // ADMIN ONLY: ban ip from submit benchmark
 func banIP(ip string) {
 	// if ip is in db then remove all its benchmark submissions
@@ -327,6 +916,13 @@ func removeBenchmark(client *mongo.Client, submissionID string) {
 
 	collection.DeleteOne(ctx, bson.M{"submissionid": submissionID})
 }
+
+// Page size of /api/benchmarks without a limit, and the largest limit it accepts
+const (
+	defaultBenchmarksLimit = 10
+	maxBenchmarksLimit     = 500
+)
+
 func fetchBenchmarks(client *mongo.Client, filter bson.M, sortBy string, sortOrder int, page, limit int) ([]BenchmarkResult, int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -369,11 +965,251 @@ func fetchBenchmarks(client *mongo.Client, filter bson.M, sortBy string, sortOrd
 	return benchmarks, total, nil
 }
 
+// benchmarkWithID is a stored benchmark along with its document ID, the tiebreaker of the
+// pagination keyset
+type benchmarkWithID struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	BenchmarkResult `bson:",inline"`
+}
+
+// errInvalidCursor is returned for a pagination cursor that wasn't made by encodeBenchmarkCursor
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeBenchmarkCursor returns the opaque cursor pointing after the given benchmark
+func encodeBenchmarkCursor(benchmark benchmarkWithID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", benchmark.Timestamp, benchmark.ID.Hex())))
+}
+
+// decodeBenchmarkCursor parses a cursor made by encodeBenchmarkCursor
+func decodeBenchmarkCursor(cursor string) (int64, primitive.ObjectID, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, primitive.NilObjectID, errInvalidCursor
+	}
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return 0, primitive.NilObjectID, errInvalidCursor
+	}
+	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, primitive.NilObjectID, errInvalidCursor
+	}
+	id, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return 0, primitive.NilObjectID, errInvalidCursor
+	}
+	return timestamp, id, nil
+}
+
+// fetchBenchmarksAfter returns up to limit benchmarks sorted by timestamp and _id, starting
+// after cursor (empty for the first page), and the cursor of the next page, empty on the last.
+// Unlike the $skip of fetchBenchmarks, the keyset uses the index however deep the page is.
+func fetchBenchmarksAfter(client *mongo.Client, filter bson.M, sortOrder int, cursor string, limit int) ([]BenchmarkResult, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	match := filter
+	if cursor != "" {
+		timestamp, id, err := decodeBenchmarkCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		comparison := "$lt"
+		if sortOrder == 1 {
+			comparison = "$gt"
+		}
+		match = bson.M{"$and": []bson.M{filter, {"$or": []bson.M{
+			{"timestamp": bson.M{comparison: timestamp}},
+			{"timestamp": timestamp, "_id": bson.M{comparison: id}},
+		}}}}
+	}
+
+	collection := client.Database("ollamark_db").Collection("benchmarks")
+
+	// One extra document tells whether there is a next page
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$sort": bson.D{{Key: "timestamp", Value: sortOrder}, {Key: "_id", Value: sortOrder}}},
+		{"$limit": int64(limit + 1)},
+	}
+
+	results, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, "", err
+	}
+	defer results.Close(ctx)
+
+	var page []benchmarkWithID
+	if err := results.All(ctx, &page); err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(page) > limit {
+		page = page[:limit]
+		nextCursor = encodeBenchmarkCursor(page[len(page)-1])
+	}
+
+	benchmarks := make([]BenchmarkResult, len(page))
+	for i, benchmark := range page {
+		benchmarks[i] = benchmark.BenchmarkResult
+	}
+	return benchmarks, nextCursor, nil
+}
+
+// TPSStats summarizes the tokens per second of a set of benchmarks
+type TPSStats struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	StdDev float64 `json:"stddev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+// fetchTokensPerSecond returns the tokens per second of every benchmark matching the filter
+func fetchTokensPerSecond(client *mongo.Client, filter bson.M) ([]float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := client.Database("ollamark_db").Collection("benchmarks")
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"tokenspersecond": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []struct {
+		TokensPerSecond float64 `bson:"tokenspersecond"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	values := make([]float64, len(docs))
+	for i, doc := range docs {
+		values[i] = doc.TokensPerSecond
+	}
+	return values, nil
+}
+
+// computeTPSStats computes the summary statistics of values, using the population standard
+// deviation
+func computeTPSStats(values []float64) TPSStats {
+	stats := TPSStats{Count: len(values)}
+	if len(values) == 0 {
+		return stats
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	stats.Mean = sum / float64(len(sorted))
+	stats.Min = sorted[0]
+	stats.Max = sorted[len(sorted)-1]
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		stats.Median = (sorted[mid-1] + sorted[mid]) / 2
+	} else {
+		stats.Median = sorted[mid]
+	}
+
+	var variance float64
+	for _, v := range sorted {
+		variance += (v - stats.Mean) * (v - stats.Mean)
+	}
+	stats.StdDev = math.Sqrt(variance / float64(len(sorted)))
+
+	return stats
+}
+
+// ModelStats is the tokens per second summary of one model returned by /api/stats
+type ModelStats struct {
+	Model string `json:"model"`
+	TPSStats
+}
+
+// statsCacheItem caches the result of fetchModelStats for a filter
+type statsCacheItem struct {
+	Data      []ModelStats
+	Timestamp time.Time
+}
+
+// How long /api/stats results are served from the cache
+const statsCacheTTL = 30 * time.Second
+
+// fetchModelStats groups the benchmarks matching the filter by model and summarizes the
+// tokens per second of each, sorted by model name
+func fetchModelStats(client *mongo.Client, filter bson.M) ([]ModelStats, error) {
+	cacheKey := fmt.Sprintf("stats:%s", filter)
+	if item, found := cache.Load(cacheKey); found {
+		cacheItem := item.(statsCacheItem)
+		if time.Since(cacheItem.Timestamp) < statsCacheTTL {
+			return cacheItem.Data, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := client.Database("ollamark_db").Collection("benchmarks")
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$group": bson.M{"_id": "$modelname", "values": bson.M{"$push": "$tokenspersecond"}}},
+		{"$sort": bson.M{"_id": 1}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Model  string    `bson:"_id"`
+		Values []float64 `bson:"values"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	stats := make([]ModelStats, len(groups))
+	for i, group := range groups {
+		stats[i] = ModelStats{Model: group.Model, TPSStats: computeTPSStats(group.Values)}
+	}
+
+	cache.Store(cacheKey, statsCacheItem{Data: stats, Timestamp: time.Now()})
+	return stats, nil
+}
+
+// RegressionCheckRequest is the body accepted by /api/check-regression
+type RegressionCheckRequest struct {
+	ModelName       string  `json:"model_name"`
+	GPU             string  `json:"gpu"`
+	TokensPerSecond float64 `json:"tokens_per_second"`
+	Sigma           float64 `json:"sigma"`
+}
+
+// Minimum number of historical benchmarks required before flagging a regression
+const minRegressionSamples = 5
+
+// Default number of standard deviations below the median that counts as a regression
+const defaultRegressionSigma = 2.0
+
 // ProofOfWorkChallenge represents a proof-of-work challenge
 type ProofOfWorkChallenge struct {
 	Challenge  string `json:"challenge"`
 	Difficulty int    `json:"difficulty"`
 	Timestamp  int64  `json:"timestamp"`
+	Signature  string `json:"signature"`
+	// Unix time the challenge stops being accepted, so clients can expire their
+	// submission token with it instead of guessing the server's TTL
+	ExpiresAt int64 `json:"expires_at"`
 }
 
 // ProofOfWorkSolution represents a solution to a proof-of-work challenge
@@ -382,78 +1218,357 @@ type ProofOfWorkSolution struct {
 	Nonce      string `json:"nonce"`
 	Timestamp  int64  `json:"timestamp"`
 	Difficulty int    `json:"difficulty"`
+	Signature  string `json:"signature"`
+}
+
+// signChallenge returns the hex HMAC-SHA256 of "challenge|difficulty|timestamp".
+// Signing the difficulty makes the issued value authoritative: the solution is
+// checked against it rather than the load at verification time, and clients
+// can't lower it.
+func signChallenge(challenge string, difficulty int, timestamp int64, secretKey string) string {
+	mac := hmac.New(keyConfig.HMACHash, []byte(secretKey))
+	mac.Write([]byte(fmt.Sprintf("%s|%d|%d", challenge, difficulty, timestamp)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// How long an issued proof-of-work challenge can be solved and submitted, set by
+// POW_TTL_SECONDS. A longer TTL lets slow machines finish high difficulties, but keeps
+// issued challenges and solutions usable for longer, weakening replay resistance.
+var powChallengeTTL = 60 * time.Second
+
+// loadPoWTTL reads POW_TTL_SECONDS, keeping the default if it is unset
+func loadPoWTTL() error {
+	if value := os.Getenv("POW_TTL_SECONDS"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 {
+			return fmt.Errorf("invalid POW_TTL_SECONDS: %q", value)
+		}
+		powChallengeTTL = time.Duration(seconds) * time.Second
+	}
+	return nil
+}
+
+// Challenges issued within powChallengeTTL that haven't been used by a submission yet
+var issuedChallenges = make(map[string]time.Time)
+var issuedChallengesMutex sync.Mutex
+
+// rememberChallenge records an issued challenge so its solution is accepted once
+func rememberChallenge(challenge string) {
+	issuedChallengesMutex.Lock()
+	defer issuedChallengesMutex.Unlock()
+	issuedChallenges[challenge] = time.Now()
+}
+
+// consumeChallenge reports whether the challenge was issued by this server within
+// powChallengeTTL and not used before, and removes it so it can't be reused
+func consumeChallenge(challenge string) bool {
+	issuedChallengesMutex.Lock()
+	defer issuedChallengesMutex.Unlock()
+	issuedAt, ok := issuedChallenges[challenge]
+	if !ok {
+		return false
+	}
+	delete(issuedChallenges, challenge)
+	return time.Since(issuedAt) <= powChallengeTTL
+}
+
+// Periodically drop challenges that were never used and have expired
+func StartIssuedChallengeCleanup() {
+	ticker := time.NewTicker(1 * time.Minute)
+	go func() {
+		for {
+			<-ticker.C
+			issuedChallengesMutex.Lock()
+			for challenge, issuedAt := range issuedChallenges {
+				if time.Since(issuedAt) > powChallengeTTL {
+					delete(issuedChallenges, challenge)
+				}
+			}
+			issuedChallengesMutex.Unlock()
+		}
+	}()
 }
 
 // GenerateProofOfWorkChallenge generates a new proof-of-work challenge
-func GenerateProofOfWorkChallenge() ProofOfWorkChallenge {
+func GenerateProofOfWorkChallenge(secretKey string) ProofOfWorkChallenge {
 	difficulty := GetDynamicDifficulty()
 	// log.Printf("Generated PoW challenge with difficulty: %d", difficulty)
+	RecordChallengeIssued(difficulty)
 	challenge := make([]byte, 32)
 	rand.Read(challenge)
-	return ProofOfWorkChallenge{
+	powChallenge := ProofOfWorkChallenge{
 		Challenge:  hex.EncodeToString(challenge),
 		Difficulty: difficulty,
 		Timestamp:  time.Now().Unix(),
 	}
+	powChallenge.ExpiresAt = powChallenge.Timestamp + int64(powChallengeTTL/time.Second)
+	powChallenge.Signature = signChallenge(powChallenge.Challenge, powChallenge.Difficulty, powChallenge.Timestamp, secretKey)
+	rememberChallenge(powChallenge.Challenge)
+	return powChallenge
 }
 
-// VerifyProofOfWork checks if the provided solution is valid
-func VerifyProofOfWork(challenge string, nonce string, difficulty int, timestamp int64) bool {
-	// Check if the challenge is expired (e.g., valid for 1 minute)
-	if time.Now().Unix()-timestamp > 60 {
+// VerifyProofOfWork checks if the provided solution is valid, for a challenge signed with
+// any of keys
+func VerifyProofOfWork(solution ProofOfWorkSolution, keys []string) bool {
+	challenge, nonce, difficulty, timestamp := solution.Challenge, solution.Nonce, solution.Difficulty, solution.Timestamp
+
+	// Check if the challenge is expired
+	if time.Now().Unix()-timestamp > int64(powChallengeTTL/time.Second) {
+		return false
+	}
+	// Check that the challenge, difficulty and timestamp are the ones issued
+	signed := false
+	for _, key := range keys {
+		expectedSignature := signChallenge(challenge, difficulty, timestamp, key)
+		if hmac.Equal([]byte(solution.Signature), []byte(expectedSignature)) {
+			signed = true
+			break
+		}
+	}
+	if !signed {
 		return false
 	}
 	data := challenge + nonce
 	hash := sha256.Sum256([]byte(data))
 	hashStr := hex.EncodeToString(hash[:])
 	prefix := strings.Repeat("0", difficulty)
-	return strings.HasPrefix(hashStr, prefix)
+	if !strings.HasPrefix(hashStr, prefix) {
+		return false
+	}
+	// Only checked once the solution is valid, so a bad attempt doesn't burn the challenge
+	return consumeChallenge(challenge)
+}
+
+// submissionWindow is the sliding window over which submissions count towards the
+// difficulty, the breakpoints are submissions per window
+const submissionWindow = time.Minute
+
+// submissionRingSize bounds the remembered submissions and so the highest load that can be
+// measured, loadDifficultyConfig rejects breakpoints at or above it
+const submissionRingSize = 1 << 14
+
+// submissionRing holds the times of the submissions in the last submissionWindow, oldest
+// first, so the load doesn't drop to zero at a fixed reset like a per-minute counter
+type submissionRing struct {
+	// The ring has its own lock: the counter it replaced was atomic, so there was no
+	// submission mutex to reuse, and sharing recentSubmissionsMutex would make every
+	// challenge request wait on the resubmission checks
+	mutex sync.Mutex
+	times [submissionRingSize]time.Time
+	start int
+	count int
 }
 
-var submissionCount int
-var submissionCountMutex sync.Mutex
+var submissions submissionRing
 
-// IncrementSubmissionCount increments the submission count
-func IncrementSubmissionCount() {
-	submissionCountMutex.Lock()
-	defer submissionCountMutex.Unlock()
-	submissionCount++
+// evict drops the submissions older than the window, the caller holds the mutex
+func (r *submissionRing) evict(now time.Time) {
+	for r.count > 0 && now.Sub(r.times[r.start]) > submissionWindow {
+		r.start = (r.start + 1) % submissionRingSize
+		r.count--
+	}
+}
+
+// add records a submission, overwriting the oldest one when the ring is full
+func (r *submissionRing) add(now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.evict(now)
+	if r.count == submissionRingSize {
+		r.start = (r.start + 1) % submissionRingSize
+		r.count--
+	}
+	r.times[(r.start+r.count)%submissionRingSize] = now
+	r.count++
 }
 
-// ResetSubmissionCount resets the submission count
-func ResetSubmissionCount() {
-	submissionCountMutex.Lock()
-	defer submissionCountMutex.Unlock()
-	submissionCount = 0
+// recent returns the number of submissions in the window ending at now
+func (r *submissionRing) recent(now time.Time) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.evict(now)
+	return r.count
 }
 
-// GetSubmissionCount returns the current submission count
+// IncrementSubmissionCount records a submission
+func IncrementSubmissionCount() {
+	submissions.add(time.Now())
+}
+
+// GetSubmissionCount returns the number of submissions in the last submissionWindow
 func GetSubmissionCount() int {
-	submissionCountMutex.Lock()
-	defer submissionCountMutex.Unlock()
-	return submissionCount
+	return submissions.recent(time.Now())
 }
 
-// Periodically reset the submission count (e.g., every minute)
-func StartSubmissionCountReset() {
-	ticker := time.NewTicker(1 * time.Minute)
-	go func() {
-		for {
-			<-ticker.C
-			ResetSubmissionCount()
+// Counts of proof-of-work challenges issued and solved per difficulty since startup
+var powIssued = map[int]int{}
+var powSolved = map[int]int{}
+var powStatsMutex sync.Mutex
+
+// RecordChallengeIssued counts a challenge issued at the given difficulty
+func RecordChallengeIssued(difficulty int) {
+	powStatsMutex.Lock()
+	defer powStatsMutex.Unlock()
+	powIssued[difficulty]++
+}
+
+// RecordChallengeSolved counts a valid solution at the given difficulty
+func RecordChallengeSolved(difficulty int) {
+	powStatsMutex.Lock()
+	defer powStatsMutex.Unlock()
+	powSolved[difficulty]++
+}
+
+// GetPoWStats returns copies of the issued and solved counts per difficulty
+func GetPoWStats() (map[int]int, map[int]int) {
+	powStatsMutex.Lock()
+	defer powStatsMutex.Unlock()
+	issued := make(map[int]int, len(powIssued))
+	for difficulty, count := range powIssued {
+		issued[difficulty] = count
+	}
+	solved := make(map[int]int, len(powSolved))
+	for difficulty, count := range powSolved {
+		solved[difficulty] = count
+	}
+	return issued, solved
+}
+
+// DifficultyConfig shapes GetDynamicDifficulty. Each breakpoint (submissions per
+// minute) the load exceeds adds one to MinDifficulty; beyond the last breakpoint
+// every doubling of the load adds one more, up to MaxDifficulty.
+type DifficultyConfig struct {
+	MinDifficulty int
+	MaxDifficulty int
+	Breakpoints   []int
+}
+
+// Defaults matching the original 50 -> 5, 100 -> 6 steps. Each level is 16 times the work
+// and must stay solvable within the challenge TTL, so operators raise POW_MAX_DIFFICULTY
+// together with POW_TTL_SECONDS.
+var difficultyConfig = DifficultyConfig{
+	MinDifficulty: 4,
+	MaxDifficulty: 6,
+	Breakpoints:   []int{50, 100},
+}
+
+// loadDifficultyConfig reads POW_MIN_DIFFICULTY, POW_MAX_DIFFICULTY and
+// POW_DIFFICULTY_BREAKPOINTS (comma-separated, ascending), keeping the
+// defaults for unset variables
+func loadDifficultyConfig() (DifficultyConfig, error) {
+	config := difficultyConfig
+
+	if value := os.Getenv("POW_MIN_DIFFICULTY"); value != "" {
+		difficulty, err := strconv.Atoi(value)
+		if err != nil || difficulty < 1 {
+			return config, fmt.Errorf("invalid POW_MIN_DIFFICULTY: %q", value)
 		}
-	}()
+		config.MinDifficulty = difficulty
+	}
+
+	if value := os.Getenv("POW_MAX_DIFFICULTY"); value != "" {
+		difficulty, err := strconv.Atoi(value)
+		if err != nil || difficulty > 64 {
+			return config, fmt.Errorf("invalid POW_MAX_DIFFICULTY: %q", value)
+		}
+		config.MaxDifficulty = difficulty
+	}
+
+	if config.MaxDifficulty < config.MinDifficulty {
+		return config, fmt.Errorf("POW_MAX_DIFFICULTY (%d) is below POW_MIN_DIFFICULTY (%d)", config.MaxDifficulty, config.MinDifficulty)
+	}
+
+	if value := os.Getenv("POW_DIFFICULTY_BREAKPOINTS"); value != "" {
+		var breakpoints []int
+		for _, field := range strings.Split(value, ",") {
+			breakpoint, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || breakpoint < 1 {
+				return config, fmt.Errorf("invalid POW_DIFFICULTY_BREAKPOINTS: %q", value)
+			}
+			if len(breakpoints) > 0 && breakpoint <= breakpoints[len(breakpoints)-1] {
+				return config, fmt.Errorf("POW_DIFFICULTY_BREAKPOINTS must be ascending: %q", value)
+			}
+			// The ring counts at most submissionRingSize submissions, a higher load is never measured
+			if breakpoint >= submissionRingSize {
+				return config, fmt.Errorf("POW_DIFFICULTY_BREAKPOINTS must be below %d submissions per window: %q", submissionRingSize, value)
+			}
+			breakpoints = append(breakpoints, breakpoint)
+		}
+		config.Breakpoints = breakpoints
+	}
+
+	return config, nil
+}
+
+// difficultyForLoad maps the submissions in the last submissionWindow to a difficulty
+func difficultyForLoad(config DifficultyConfig, count int) int {
+	difficulty := config.MinDifficulty
+	for _, breakpoint := range config.Breakpoints {
+		if count > breakpoint {
+			difficulty++
+		}
+	}
+
+	// Keep adapting past the last breakpoint: one more level per doubling
+	if len(config.Breakpoints) > 0 {
+		for threshold := config.Breakpoints[len(config.Breakpoints)-1] * 2; count > threshold && difficulty < config.MaxDifficulty; threshold *= 2 {
+			difficulty++
+		}
+	}
+
+	if difficulty > config.MaxDifficulty {
+		return config.MaxDifficulty
+	}
+	return difficulty
 }
 
 // GetDynamicDifficulty calculates the difficulty based on the current load
 func GetDynamicDifficulty() int {
-	count := GetSubmissionCount()
-	if count > 100 {
-		return 6 // High load, increase difficulty
-	} else if count > 50 {
-		return 5 // Medium load, moderate difficulty
+	return difficultyForLoad(difficultyConfig, GetSubmissionCount())
+}
+
+// withoutIPs returns a copy of benchmarks with the submitter IPs removed. The slice may be
+// shared with the cache, so it is never modified in place.
+func withoutIPs(benchmarks []BenchmarkResult) []BenchmarkResult {
+	stripped := make([]BenchmarkResult, len(benchmarks))
+	for i, benchmark := range benchmarks {
+		benchmark.IP = ""
+		stripped[i] = benchmark
+	}
+	return stripped
+}
+
+// machineHistoryHandler lists the submissions of one machine fingerprint, oldest first.
+// The fingerprint is public, the IPs the machine submitted from are not.
+func machineHistoryHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fingerprint := strings.ToLower(c.Param("fingerprint"))
+		if !machineIDPattern.MatchString(fingerprint) {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid machine fingerprint")
+			return
+		}
+
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if page < 1 {
+			page = 1
+		}
+		if limit < 1 {
+			limit = 100
+		}
+		if limit > maxBenchmarksLimit {
+			limit = maxBenchmarksLimit
+		}
+
+		// Oldest first so the history reads as a timeline for drift detection
+		benchmarks, total, err := fetchBenchmarks(client, bson.M{"machineid": fingerprint}, "timestamp", 1, page, limit)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeDatabase, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"machine_id": fingerprint, "benchmarks": withoutIPs(benchmarks), "total": total})
 	}
-	return 4 // Low load, default difficulty
 }
 
 func main() {
@@ -471,7 +1586,44 @@ func main() {
 		panic(err)
 	}
 
-	secretKey := os.Getenv("KEY")
+	keyConfig, err = loadKeyConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	difficultyConfig, err = loadDifficultyConfig()
+	if err != nil {
+		panic(err)
+	}
+	if reachable := difficultyForLoad(difficultyConfig, submissionRingSize); reachable < difficultyConfig.MaxDifficulty {
+		log.Printf("POW_MAX_DIFFICULTY %d is never reached, the highest measurable load of %d submissions per window gives %d\n", difficultyConfig.MaxDifficulty, submissionRingSize, reachable)
+	}
+
+	if err := loadResubmissionConfig(); err != nil {
+		panic(err)
+	}
+
+	if err := loadPoWTTL(); err != nil {
+		panic(err)
+	}
+
+	if _, err := reloadModels(); err != nil {
+		panic(err)
+	}
+
+	// Reload the model allowlist on SIGHUP, no redeploy needed for new models
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
+	go func() {
+		for range reloadSignal {
+			models, err := reloadModels()
+			if err != nil {
+				log.Printf("Failed to reload models, keeping the current list: %v\n", err)
+				continue
+			}
+			log.Printf("Reloaded %d models\n", len(models))
+		}
+	}()
 
 	client, err := connectDB()
 	if err != nil {
@@ -479,51 +1631,142 @@ func main() {
 	}
 	defer client.Disconnect(context.Background())
 
+	if err := ensureQueryIndexes(client); err != nil {
+		log.Printf("Failed to create indexes: %v\n", err)
+	}
+	if err := ensureSubmissionIDIndex(client); err != nil {
+		log.Printf("WARNING: failed to create the unique submissionid index, the database does not reject duplicate submissions. Remove the duplicate submission IDs and restart: %v\n", err)
+	}
+
 	// admin commands?
 
 	r := gin.Default()
 	r.Use(cors.Default()) // Enable CORS for all routes
 
-	// Rate limiter configuration: max 10 requests per 5s per IP
-	limiter := tollbooth.NewLimiter(10, &limiter.ExpirableOptions{DefaultExpirationTTL: 5 * time.Second})
-
-	StartSubmissionCountReset()
+	// Health probes are registered before the rate limiter so load balancers never get throttled
+	r.GET("/healthz", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
 
-	// Middleware to apply the rate limiter
-	r.Use(func(c *gin.Context) {
-		httpError := tollbooth.LimitByRequest(limiter, c.Writer, c.Request)
-		if httpError != nil {
-			c.JSON(httpError.StatusCode, gin.H{"error": httpError.Message})
-			c.Abort()
+	r.GET("/readyz", func(c *gin.Context) {
+		if err := pingDB(client); err != nil {
+			log.Printf("Readiness check failed: %v\n", err)
+			respondError(c, http.StatusServiceUnavailable, ErrCodeDatabase, "Database unreachable")
 			return
 		}
-		c.Next()
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
-	r.GET("/api/model-list", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"models": MODELS})
+	StartIssuedChallengeCleanup()
+	StartIPRequestsCleanup()
+	StartRecentSubmissionsCleanup()
+
+	r.Use(gzipMiddleware())
+
+	// Rate limiters are per route group: submissions and challenges get the strict limit
+	// (max 10 requests per 5s per IP), read endpoints a looser one so leaderboard pages
+	// firing several requests on load aren't throttled
+	submitLimiter := tollbooth.NewLimiter(10, &limiter.ExpirableOptions{DefaultExpirationTTL: 5 * time.Second})
+	readLimiter := tollbooth.NewLimiter(50, &limiter.ExpirableOptions{DefaultExpirationTTL: 5 * time.Second})
+
+	writes := r.Group("", rateLimitMiddleware(submitLimiter))
+	reads := r.Group("", rateLimitMiddleware(readLimiter))
+
+	reads.GET("/api/model-list", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"models": getModels()})
 	})
 
-	r.GET("/api/benchmark/:submissionid", func(c *gin.Context) {
+	reads.GET("/api/benchmark/:submissionid", func(c *gin.Context) {
 		submissionID := c.Param("submissionid")
 		collection := client.Database("ollamark_db").Collection("benchmarks")
 
 		var benchmark BenchmarkResult
 		err := collection.FindOne(context.Background(), bson.M{"submissionid": submissionID}).Decode(&benchmark)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Benchmark not found"})
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "Benchmark not found")
 			return
 		}
 
 		c.JSON(http.StatusOK, benchmark)
 	})
 
-	r.GET("/api/pow-challenge", func(c *gin.Context) {
-		challenge := GenerateProofOfWorkChallenge()
+	reads.GET("/api/machine/:fingerprint", machineHistoryHandler(client))
+
+	reads.POST("/api/check-regression", func(c *gin.Context) {
+		var request RegressionCheckRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request payload")
+			return
+		}
+
+		if request.ModelName == "" || request.GPU == "" || request.TokensPerSecond <= 0 {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "model_name, gpu and tokens_per_second are required")
+			return
+		}
+
+		sigma := request.Sigma
+		if sigma <= 0 {
+			sigma = defaultRegressionSigma
+		}
+
+		filter := bson.M{
+			"modelname":    request.ModelName,
+			"gpuinfo.name": bson.M{"$regex": regexp.QuoteMeta(request.GPU), "$options": "i"},
+		}
+		values, err := fetchTokensPerSecond(client, filter)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeDatabase, err.Error())
+			return
+		}
+
+		stats := computeTPSStats(values)
+		threshold := stats.Median - sigma*stats.StdDev
+		sufficientData := stats.Count >= minRegressionSamples
+
+		c.JSON(http.StatusOK, gin.H{
+			"model_name":        request.ModelName,
+			"gpu":               request.GPU,
+			"tokens_per_second": request.TokensPerSecond,
+			"sigma":             sigma,
+			"baseline":          stats,
+			"threshold":         threshold,
+			"sufficient_data":   sufficientData,
+			"regression":        sufficientData && request.TokensPerSecond < threshold,
+		})
+	})
+
+	writes.GET("/api/pow-challenge", func(c *gin.Context) {
+		challenge := GenerateProofOfWorkChallenge(keyConfig.CurrentKey)
 		c.JSON(http.StatusOK, challenge)
 	})
 
-	r.GET("/api/benchmarks", func(c *gin.Context) {
+	// Current difficulty without issuing a challenge, so clients can show a solve estimate
+	reads.GET("/api/pow-difficulty", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"difficulty": GetDynamicDifficulty()})
+	})
+
+	// Proof-of-work difficulty distribution, used to tune GetDynamicDifficulty
+	writes.GET("/api/admin/pow-stats", adminMiddleware(), func(c *gin.Context) {
+		issued, solved := GetPoWStats()
+		c.JSON(http.StatusOK, gin.H{
+			"submission_count":   GetSubmissionCount(),
+			"current_difficulty": GetDynamicDifficulty(),
+			"issued":             issued,
+			"solved":             solved,
+		})
+	})
+
+	// Reload the model allowlist from MODELS_FILE or MODELS_JSON, like SIGHUP
+	writes.POST("/api/admin/reload-models", adminMiddleware(), func(c *gin.Context) {
+		models, err := reloadModels()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeInvalidConfig, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"models": models})
+	})
+
+	reads.GET("/api/benchmarks", func(c *gin.Context) {
 		sortBy := c.DefaultQuery("sort_by", "timestamp")
 		order := c.DefaultQuery("order", "desc")
 		modelFilter := c.DefaultQuery("model", "")
@@ -531,6 +1774,12 @@ func main() {
 		osFilter := c.DefaultQuery("os", "")
 		cpuFilter := c.DefaultQuery("cpu", "")
 		gpuFilter := c.DefaultQuery("gpu", "")
+		environmentFilter := c.DefaultQuery("environment", "")
+		clientTypeFilter := c.DefaultQuery("client_type", "")
+		clientVersionFilter := c.DefaultQuery("client_version", "")
+		userAgentFilter := c.DefaultQuery("user_agent", "")
+		tagFilter := strings.ToLower(c.DefaultQuery("tag", ""))
+		promptHashFilter := strings.ToLower(c.DefaultQuery("prompt_hash", ""))
 		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
 
@@ -541,9 +1790,17 @@ func main() {
 			sortOrder = -1
 		}
 
-		if limit == 0 {
-			// Set a large limit value when limit is 0
-			limit = 1000000 // Adjust this value according to your needs
+		// A negative page would turn into a negative $skip, which Mongo rejects
+		if page < 1 {
+			page = 1
+		}
+		// limit=0 means the default page size, larger limits are clamped so no request
+		// pulls the whole collection into memory
+		if limit <= 0 {
+			limit = defaultBenchmarksLimit
+		}
+		if limit > maxBenchmarksLimit {
+			limit = maxBenchmarksLimit
 		}
 
 		filter := bson.M{}
@@ -562,20 +1819,157 @@ func main() {
 		if ollamaVersionFilter != "" {
 			filter["ollamaversion"] = ollamaVersionFilter
 		}
+		if environmentFilter != "" {
+			filter["environment"] = environmentFilter
+		}
+		if clientTypeFilter != "" {
+			filter["clienttype"] = clientTypeFilter
+		}
+		if clientVersionFilter != "" {
+			filter["clientversion"] = clientVersionFilter
+		}
+		if userAgentFilter != "" {
+			filter["useragent"] = bson.M{"$regex": userAgentFilter, "$options": "i"}
+		}
+		if tagFilter != "" {
+			filter["tags"] = tagFilter
+		}
+		// Only runs with the same prompt are truly comparable
+		if promptHashFilter != "" {
+			filter["prompthash"] = promptHashFilter
+		}
+		// Tokens per second range to exclude outliers, unparseable bounds are ignored
+		tpsRange := bson.M{}
+		if minTPS, err := strconv.ParseFloat(c.Query("min_tps"), 64); err == nil && !math.IsNaN(minTPS) {
+			tpsRange["$gte"] = minTPS
+		}
+		if maxTPS, err := strconv.ParseFloat(c.Query("max_tps"), 64); err == nil && !math.IsNaN(maxTPS) {
+			tpsRange["$lte"] = maxTPS
+		}
+		if len(tpsRange) > 0 {
+			filter["tokenspersecond"] = tpsRange
+		}
+		// Timestamp window in unix seconds, e.g. the benchmarks of the last 7 days
+		window := bson.M{}
+		var since, until int64
+		if value := c.Query("since"); value != "" {
+			var err error
+			if since, err = strconv.ParseInt(value, 10, 64); err != nil {
+				respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "since must be a unix timestamp")
+				return
+			}
+			window["$gte"] = since
+		}
+		if value := c.Query("until"); value != "" {
+			var err error
+			if until, err = strconv.ParseInt(value, 10, 64); err != nil {
+				respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "until must be a unix timestamp")
+				return
+			}
+			window["$lte"] = until
+		}
+		if len(window) == 2 && since > until {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "since must not be after until")
+			return
+		}
+		if len(window) > 0 {
+			filter["timestamp"] = window
+		}
+
+		// Keyset pagination, "cursor=" for the first page, then the returned next_cursor
+		if cursor, ok := c.GetQuery("cursor"); ok {
+			if sortBy != "timestamp" {
+				respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "cursor pagination requires sort_by=timestamp")
+				return
+			}
+			benchmarks, nextCursor, err := fetchBenchmarksAfter(client, filter, sortOrder, cursor, limit)
+			if err != nil {
+				if err == errInvalidCursor {
+					respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid cursor")
+				} else {
+					respondError(c, http.StatusInternalServerError, ErrCodeDatabase, err.Error())
+				}
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"benchmarks": benchmarks, "next_cursor": nextCursor})
+			return
+		}
 
 		benchmarks, total, err := fetchBenchmarks(client, filter, sortBy, sortOrder, page, limit)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, http.StatusInternalServerError, ErrCodeDatabase, err.Error())
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"benchmarks": benchmarks, "total": total})
+		totalPages := (total + int64(limit) - 1) / int64(limit)
+		c.JSON(http.StatusOK, gin.H{
+			"benchmarks":  benchmarks,
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": totalPages,
+		})
 	})
 
-	r.POST("/api/submit-benchmark", authMiddleware(), func(c *gin.Context) {
+	// Tokens per second per model for dashboards, optionally for a single ?model=
+	reads.GET("/api/stats", func(c *gin.Context) {
+		filter := bson.M{}
+		if model := c.Query("model"); model != "" {
+			filter["modelname"] = model
+		}
+
+		stats, err := fetchModelStats(client, filter)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeDatabase, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"models": stats})
+	})
+
+	// Head-to-head tokens per second of two GPUs on the same model
+	reads.GET("/api/compare", func(c *gin.Context) {
+		model := c.Query("model")
+		gpuA := c.Query("gpu_a")
+		gpuB := c.Query("gpu_b")
+		if model == "" || gpuA == "" || gpuB == "" {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "model, gpu_a and gpu_b are required")
+			return
+		}
+
+		var stats [2]TPSStats
+		for i, gpu := range []string{gpuA, gpuB} {
+			filter := bson.M{
+				"modelname":    model,
+				"gpuinfo.name": bson.M{"$regex": regexp.QuoteMeta(gpu), "$options": "i"},
+			}
+			values, err := fetchTokensPerSecond(client, filter)
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, ErrCodeDatabase, err.Error())
+				return
+			}
+			stats[i] = computeTPSStats(values)
+		}
+
+		// How much faster gpu_a is than gpu_b, null without data for both
+		var differencePercent *float64
+		if stats[0].Count > 0 && stats[1].Count > 0 && stats[1].Mean > 0 {
+			difference := (stats[0].Mean - stats[1].Mean) / stats[1].Mean * 100
+			differencePercent = &difference
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"model":              model,
+			"gpu_a":              gin.H{"name": gpuA, "count": stats[0].Count, "average": stats[0].Mean},
+			"gpu_b":              gin.H{"name": gpuB, "count": stats[1].Count, "average": stats[1].Mean},
+			"difference_percent": differencePercent,
+		})
+	})
+
+	writes.POST("/api/submit-benchmark", authMiddleware(client), func(c *gin.Context) {
 		encryptedData, err := io.ReadAll(c.Request.Body)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request payload")
 			fmt.Printf("Invalid request payload: %v", err)
 			return
 		}
@@ -583,8 +1977,8 @@ func main() {
 		submissionID := c.GetHeader("X-Submission-ID")
 		signature := c.GetHeader("X-Signature")
 
-		if !verifySignature(submissionID, signature, secretKey) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		if !verifySignature(submissionID, signature) {
+			respondError(c, http.StatusUnauthorized, ErrCodeInvalidSignature, "Invalid signature")
 			fmt.Printf("Invalid signature: %v", err)
 			return
 		}
@@ -592,19 +1986,19 @@ func main() {
 		// Check for replay attacks by storing and checking used submission IDs
 		isUnique, err := checkSubmissionID(client, submissionID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check submission"})
+			respondError(c, http.StatusInternalServerError, ErrCodeDatabase, "Failed to check submission")
 			fmt.Printf("Failed to check submission ID: %v", err)
 			return
 		}
 
 		if !isUnique {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not a unique submission"})
+			respondError(c, http.StatusUnauthorized, ErrCodeDuplicateSubmission, "Not a unique submission")
 			return
 		}
 
 		var payload map[string]string
 		if err := json.Unmarshal(encryptedData, &payload); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload format"})
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid payload format")
 			fmt.Printf("Invalid payload format: %v", err)
 			return
 		}
@@ -616,7 +2010,7 @@ func main() {
 		// Decrypt AES key with RSA private key
 		aesKey, err := DecryptData(privateKey, encryptedAESKey)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Decryption failed"})
+			respondError(c, http.StatusUnauthorized, ErrCodeDecryptionFailed, "Decryption failed")
 			fmt.Printf("Decryption failed: %v", err)
 			return
 		}
@@ -624,39 +2018,67 @@ func main() {
 		// Decrypt data with AES key
 		decryptedData, err := decryptAESGCM(aesKey, nonce, ciphertext)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Decryption failed"})
+			respondError(c, http.StatusUnauthorized, ErrCodeDecryptionFailed, "Decryption failed")
 			fmt.Printf("Decryption failed: %v", err)
 			return
 		}
 
 		var benchmarkResult BenchmarkResult
 		if err := json.Unmarshal(decryptedData, &benchmarkResult); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid benchmark data"})
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidBenchmark, "Invalid benchmark data")
 			fmt.Printf("Invalid benchmark data: %v", err)
 			return
 		}
 
 		// Basic verification of benchmark data
 		if benchmarkResult.EvalCount <= 0 || benchmarkResult.TokensPerSecond <= 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid benchmark metrics"})
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidBenchmark, "Invalid benchmark metrics")
+			return
+		}
+
+		// Partial results of cancelled benchmarks don't belong on the leaderboard
+		if benchmarkResult.Cancelled {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidBenchmark, "Cancelled benchmarks can't be submitted")
+			return
+		}
+
+		if err := validateIterations(benchmarkResult); err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidBenchmark, err.Error())
+			return
+		}
+
+		if err := validateTPSSpread(benchmarkResult); err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidBenchmark, err.Error())
+			return
+		}
+
+		benchmarkResult.Tags, err = sanitizeTags(benchmarkResult.Tags)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidBenchmark, err.Error())
 			return
 		}
 
 		// Validate the modelName against the predefined list
-		if !contains(MODELS, benchmarkResult.ModelName) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid model name"})
+		if !contains(getModels(), benchmarkResult.ModelName) {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidModel, "Invalid model name")
 			return
 		}
 
 		// Verify proof-of-work
-		if !VerifyProofOfWork(benchmarkResult.ProofOfWork.Challenge, benchmarkResult.ProofOfWork.Nonce, benchmarkResult.ProofOfWork.Difficulty, benchmarkResult.ProofOfWork.Timestamp) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid proof-of-work solution"})
+		if !VerifyProofOfWork(benchmarkResult.ProofOfWork, keyConfig.verificationKeys()) {
+			respondError(c, http.StatusUnauthorized, ErrCodeInvalidPoW, "Invalid proof-of-work solution")
 			return
 		}
+		RecordChallengeSolved(benchmarkResult.ProofOfWork.Difficulty)
 
 		checkedIP := checkIP(benchmarkResult.IP)
 		if !checkedIP {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "IP address is rate limited"})
+			respondError(c, http.StatusUnauthorized, ErrCodeIPRateLimited, "IP address is rate limited")
+			return
+		}
+
+		if !checkResubmission(machineFingerprint(benchmarkResult.SysInfo, benchmarkResult.GPUInfo), benchmarkResult.ModelName) {
+			respondError(c, http.StatusTooManyRequests, ErrCodeResubmissionLimited, "Too many submissions of this model from this machine, try again later")
 			return
 		}
 
@@ -664,10 +2086,17 @@ func main() {
 		log.Printf("SysInfo: %+v\n", *benchmarkResult.SysInfo)
 		log.Printf("GPUInfo: %+v\n", *benchmarkResult.GPUInfo)
 		benchmarkResult.SubmissionID = submissionID
+		benchmarkResult.MachineID = machineFingerprint(benchmarkResult.SysInfo, benchmarkResult.GPUInfo)
+		benchmarkResult.UserAgent = c.Request.UserAgent()
 
 		// Insert benchmarks into the MongoDB
 		err = insertBenchmark(client, benchmarkResult)
+		if mongo.IsDuplicateKeyError(err) {
+			respondError(c, http.StatusConflict, ErrCodeDuplicateSubmission, "Not a unique submission")
+			return
+		}
 		if err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeDatabase, "Failed to store benchmark")
 			fmt.Printf("Failed to insert benchmark: %v", err)
 			return
 		}
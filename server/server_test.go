@@ -0,0 +1,495 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// resetIPRequests empties the per-IP rate limiter maps and widens the time window so
+// slow runs under -race don't reset the counts midway
+func resetIPRequests(t *testing.T) {
+	t.Helper()
+	savedWindow := timeWindow
+	ipRequestsMutex.Lock()
+	ipRequests = make(map[string]int)
+	ipLastRequest = make(map[string]time.Time)
+	timeWindow = time.Hour
+	ipRequestsMutex.Unlock()
+	t.Cleanup(func() {
+		ipRequestsMutex.Lock()
+		ipRequests = make(map[string]int)
+		ipLastRequest = make(map[string]time.Time)
+		timeWindow = savedWindow
+		ipRequestsMutex.Unlock()
+	})
+}
+
+func TestCheckIPConcurrent(t *testing.T) {
+	resetIPRequests(t)
+
+	const ips = 8
+	const requestsPerIP = 200
+
+	var wg sync.WaitGroup
+	var allowedMutex sync.Mutex
+	allowed := make(map[string]int)
+	for i := 0; i < ips; i++ {
+		ip := fmt.Sprintf("10.0.0.%d", i)
+		for j := 0; j < requestsPerIP; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if checkIP(ip) {
+					allowedMutex.Lock()
+					allowed[ip]++
+					allowedMutex.Unlock()
+				}
+			}()
+		}
+	}
+
+	// Sweep while the requests are running, nothing is old enough to be removed yet
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				sweepIPRequests(time.Now())
+			}
+		}
+	}()
+	wg.Wait()
+	close(done)
+
+	for i := 0; i < ips; i++ {
+		ip := fmt.Sprintf("10.0.0.%d", i)
+		if allowed[ip] != requestLimit {
+			t.Errorf("%s: %d requests allowed, want %d", ip, allowed[ip], requestLimit)
+		}
+	}
+
+	sweepIPRequests(time.Now().Add(2 * timeWindow))
+	ipRequestsMutex.Lock()
+	defer ipRequestsMutex.Unlock()
+	if len(ipRequests) != 0 || len(ipLastRequest) != 0 {
+		t.Errorf("sweep left %d counts and %d timestamps", len(ipRequests), len(ipLastRequest))
+	}
+}
+
+// setKeyConfig replaces the key configuration for the duration of the test
+func setKeyConfig(t *testing.T, config KeyConfig) {
+	t.Helper()
+	saved := keyConfig
+	keyConfig = config
+	t.Cleanup(func() { keyConfig = saved })
+}
+
+func TestAuthMiddlewareReusesClient(t *testing.T) {
+	setKeyConfig(t, KeyConfig{CurrentKey: "current", JWTAlgorithm: "HS256"})
+
+	var connects int32
+	savedConnect := mongoConnect
+	mongoConnect = func(ctx context.Context, opts ...*options.ClientOptions) (*mongo.Client, error) {
+		atomic.AddInt32(&connects, 1)
+		return savedConnect(ctx, opts...)
+	}
+	t.Cleanup(func() { mongoConnect = savedConnect })
+
+	// Nothing listens on the port, so every submission check fails fast with a database error
+	clientOptions := options.Client().ApplyURI("mongodb://127.0.0.1:1").SetServerSelectionTimeout(50 * time.Millisecond)
+	client, err := mongo.Connect(context.Background(), clientOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/submit", authMiddleware(client), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for i := 0; i < 5; i++ {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"nonce": fmt.Sprintf("submission-%d", i),
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		}).SignedString([]byte("current"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/submit", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		// The request got past the JWT check and queried the shared client
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("request %d: status %d, want %d: %s", i, w.Code, http.StatusInternalServerError, w.Body)
+		}
+	}
+
+	if n := atomic.LoadInt32(&connects); n != 0 {
+		t.Errorf("authMiddleware opened %d clients, want 0", n)
+	}
+}
+
+func TestDifficultyForLoadDefaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+		want  int
+	}{
+		{"idle", 0, 4},
+		{"at first breakpoint", 50, 4},
+		{"past first breakpoint", 51, 5},
+		{"at second breakpoint", 100, 5},
+		{"past second breakpoint", 101, 6},
+		{"capped past second breakpoint", 201, 6},
+		{"spike", 10000, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := difficultyForLoad(difficultyConfig, tt.count); got != tt.want {
+				t.Errorf("difficultyForLoad(%d) = %d, want %d", tt.count, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDifficultyConfigFromEnv(t *testing.T) {
+	t.Setenv("POW_MIN_DIFFICULTY", "2")
+	t.Setenv("POW_MAX_DIFFICULTY", "10")
+	t.Setenv("POW_DIFFICULTY_BREAKPOINTS", "10, 20,30")
+
+	config, err := loadDifficultyConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		count int
+		want  int
+	}{
+		{0, 2},
+		{15, 3},
+		{25, 4},
+		{31, 5},
+		{61, 6},
+		{121, 7},
+		{submissionRingSize, 10},
+	}
+	for _, tt := range tests {
+		if got := difficultyForLoad(config, tt.count); got != tt.want {
+			t.Errorf("difficultyForLoad(%d) = %d, want %d", tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestDifficultyConfigInvalidEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"max below min", map[string]string{"POW_MIN_DIFFICULTY": "6", "POW_MAX_DIFFICULTY": "5"}},
+		{"max too high", map[string]string{"POW_MAX_DIFFICULTY": "65"}},
+		{"descending breakpoints", map[string]string{"POW_DIFFICULTY_BREAKPOINTS": "100,50"}},
+		{"non-numeric breakpoint", map[string]string{"POW_DIFFICULTY_BREAKPOINTS": "50,lots"}},
+		{"unmeasurable breakpoint", map[string]string{"POW_DIFFICULTY_BREAKPOINTS": fmt.Sprintf("50,%d", submissionRingSize)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			if _, err := loadDifficultyConfig(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// signSubmission mirrors the client's HMAC of the submission ID
+func signSubmission(key, submissionID string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(submissionID))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signToken(t *testing.T, key string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"nonce": "submission",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestKeyRotation(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     KeyConfig
+		previousOK bool
+	}{
+		{"overlap", KeyConfig{PreviousKey: "previous"}, true},
+		{"overlap until expiry", KeyConfig{PreviousKey: "previous", PreviousKeyExpires: time.Now().Add(time.Hour)}, true},
+		{"previous key expired", KeyConfig{PreviousKey: "previous", PreviousKeyExpires: time.Now().Add(-time.Hour)}, false},
+		{"previous key unset", KeyConfig{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.CurrentKey = "current"
+			config.JWTAlgorithm = "HS256"
+			config.HMACHash = sha256.New
+			setKeyConfig(t, config)
+
+			if !verifySignature("submission", signSubmission("current", "submission")) {
+				t.Error("signature with KEY rejected")
+			}
+			if _, err := validateJWT(signToken(t, "current")); err != nil {
+				t.Errorf("JWT with KEY rejected: %v", err)
+			}
+
+			if got := verifySignature("submission", signSubmission("previous", "submission")); got != tt.previousOK {
+				t.Errorf("signature with PREVIOUS_KEY accepted = %v, want %v", got, tt.previousOK)
+			}
+			if _, err := validateJWT(signToken(t, "previous")); (err == nil) != tt.previousOK {
+				t.Errorf("JWT with PREVIOUS_KEY accepted = %v, want %v", err == nil, tt.previousOK)
+			}
+
+			if verifySignature("submission", signSubmission("unknown", "submission")) {
+				t.Error("signature with an unknown key accepted")
+			}
+			if _, err := validateJWT(signToken(t, "unknown")); err == nil {
+				t.Error("JWT with an unknown key accepted")
+			}
+		})
+	}
+}
+
+// solveChallenge finds a nonce the way the client's solveProofOfWork does, the decimal
+// number whose hash with the challenge has the required leading zeros
+func solveChallenge(challenge ProofOfWorkChallenge) string {
+	prefix := strings.Repeat("0", challenge.Difficulty)
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		hash := sha256.Sum256([]byte(challenge.Challenge + nonce))
+		if strings.HasPrefix(hex.EncodeToString(hash[:]), prefix) {
+			return nonce
+		}
+	}
+}
+
+func TestVerifyProofOfWork(t *testing.T) {
+	setKeyConfig(t, KeyConfig{CurrentKey: "current", JWTAlgorithm: "HS256", HMACHash: sha256.New})
+
+	challenge := GenerateProofOfWorkChallenge("current")
+	if want := challenge.Timestamp + int64(powChallengeTTL/time.Second); challenge.ExpiresAt != want {
+		t.Errorf("ExpiresAt = %d, want %d", challenge.ExpiresAt, want)
+	}
+	solution := ProofOfWorkSolution{
+		Challenge:  challenge.Challenge,
+		Nonce:      solveChallenge(challenge),
+		Timestamp:  challenge.Timestamp,
+		Difficulty: challenge.Difficulty,
+		Signature:  challenge.Signature,
+	}
+
+	wrongNonce := solution
+	wrongNonce.Nonce = "not a solution"
+	if VerifyProofOfWork(wrongNonce, []string{"current"}) {
+		t.Error("wrong nonce accepted")
+	}
+
+	easier := solution
+	easier.Difficulty--
+	if VerifyProofOfWork(easier, []string{"current"}) {
+		t.Error("lowered difficulty accepted")
+	}
+
+	if !VerifyProofOfWork(solution, []string{"current"}) {
+		t.Fatal("solution rejected")
+	}
+	if VerifyProofOfWork(solution, []string{"current"}) {
+		t.Error("solution accepted twice")
+	}
+}
+
+func TestValidateIterations(t *testing.T) {
+	generationTPS := make([]float64, 120)
+	tests := []struct {
+		name    string
+		result  BenchmarkResult
+		wantErr bool
+	}{
+		{"fixed iterations", BenchmarkResult{Iterations: 10, CompletedIterations: 10, EvalCount: 1000}, false},
+		{"no iterations", BenchmarkResult{Iterations: 0, EvalCount: 1000}, true},
+		{"too many iterations", BenchmarkResult{Iterations: 51, EvalCount: 10000}, true},
+		{"completed mismatch", BenchmarkResult{Iterations: 10, CompletedIterations: 9, EvalCount: 1000}, true},
+		{"long duration run", BenchmarkResult{Iterations: 120, CompletedIterations: 120, DurationTarget: 600, GenerationTPS: generationTPS, EvalCount: 30000}, false},
+		{"duration run without generations", BenchmarkResult{Iterations: 120, DurationTarget: 600, EvalCount: 30000}, true},
+		{"duration run generation mismatch", BenchmarkResult{Iterations: 100, DurationTarget: 600, GenerationTPS: generationTPS, EvalCount: 30000}, true},
+		{"large prompt set", BenchmarkResult{Iterations: 100, CompletedIterations: 100, PromptSetHash: "hash", EvalCount: 25600}, false},
+		{"total tokens", BenchmarkResult{Iterations: 80, CompletedIterations: 80, TotalTokens: 20000, EvalCount: 20010}, false},
+		{"more generations than tokens", BenchmarkResult{Iterations: 80, TotalTokens: 50, EvalCount: 60}, true},
+		{"too many generations", BenchmarkResult{Iterations: maxGenerations + 1, PromptSetHash: "hash", EvalCount: 1 << 30}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateIterations(tt.result); (err != nil) != tt.wantErr {
+				t.Errorf("validateIterations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSweepRecentSubmissions(t *testing.T) {
+	recentSubmissionsMutex.Lock()
+	saved := recentSubmissions
+	recentSubmissions = make(map[string][]time.Time)
+	recentSubmissionsMutex.Unlock()
+	t.Cleanup(func() {
+		recentSubmissionsMutex.Lock()
+		recentSubmissions = saved
+		recentSubmissionsMutex.Unlock()
+	})
+
+	if !checkResubmission("idle-machine", "llama3") || !checkResubmission("busy-machine", "llama3") {
+		t.Fatal("first submissions throttled")
+	}
+
+	// The idle machine's submission has left the window, the busy machine submits again
+	later := time.Now().Add(resubmissionWindow)
+	recentSubmissionsMutex.Lock()
+	recentSubmissions["busy-machine|llama3"] = append(recentSubmissions["busy-machine|llama3"], later)
+	recentSubmissionsMutex.Unlock()
+
+	sweepRecentSubmissions(later.Add(time.Second))
+
+	recentSubmissionsMutex.Lock()
+	defer recentSubmissionsMutex.Unlock()
+	if _, ok := recentSubmissions["idle-machine|llama3"]; ok {
+		t.Error("idle machine not swept")
+	}
+	if got := len(recentSubmissions["busy-machine|llama3"]); got != 1 {
+		t.Errorf("busy machine has %d submissions in the window, want 1", got)
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(gzipMiddleware())
+	r.GET("/json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"benchmarks": []int{1, 2, 3}})
+	})
+	r.GET("/no-content", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	r.GET("/empty", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		path       string
+		compressed bool
+	}{
+		{"/json", true},
+		{"/no-content", false},
+		{"/empty", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if got := w.Header().Get("Content-Encoding") == "gzip"; got != tt.compressed {
+				t.Errorf("compressed = %v, want %v", got, tt.compressed)
+			}
+			if !tt.compressed && w.Body.Len() != 0 {
+				t.Errorf("body of %d bytes, want none", w.Body.Len())
+			}
+			if !tt.compressed {
+				return
+			}
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(gz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != `{"benchmarks":[1,2,3]}` {
+				t.Errorf("body %q", body)
+			}
+		})
+	}
+}
+
+func TestMachineHistoryHidesIPs(t *testing.T) {
+	fingerprint := strings.Repeat("ab", 32)
+	cached := []BenchmarkResult{
+		{ModelName: "llama3", MachineID: fingerprint, IP: "203.0.113.7"},
+		{ModelName: "llama3", MachineID: fingerprint, IP: "203.0.113.8"},
+	}
+
+	// Served from the cache, so the handler never queries the nil client
+	cacheKey := fmt.Sprintf("benchmarks:%s:%d:%d:%d:%s", "timestamp", 1, 1, 100, bson.M{"machineid": fingerprint})
+	cache.Store(cacheKey, CacheItem{Data: cached, Count: int64(len(cached)), Timestamp: time.Now()})
+	t.Cleanup(func() { cache.Delete(cacheKey) })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/machine/:fingerprint", machineHistoryHandler(nil))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/machine/"+fingerprint, nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("status %d: %s", w.Code, w.Body)
+				return
+			}
+			if strings.Contains(w.Body.String(), "203.0.113.") {
+				t.Errorf("response contains a submitter IP: %s", w.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cached[0].IP != "203.0.113.7" || cached[1].IP != "203.0.113.8" {
+		t.Errorf("cached benchmarks were modified: %+v", cached)
+	}
+}
@@ -69,15 +69,24 @@ const BenchmarkTable = () => {
 
   const fetchAllBenchmarks = async () => {
     try {
-      const response = await axios.get('http://localhost:3333/api/benchmarks', {
-        params: {
-          sort_by: "tokenspersecond",
-          order: "desc",
-          limit: 0 // Set limit to 0 to fetch all benchmarks
-        }
-      });
-      setAllBenchmarks(response.data.benchmarks || []);
-      setTotalBenchmarks(response.data.total || 0);
+      // The server caps a page at 500 benchmarks, so the site-wide counts and
+      // fastest models follow next_cursor through every page
+      let all = [];
+      let cursor = '';
+      do {
+        const response = await axios.get('http://localhost:3333/api/benchmarks', {
+          params: {
+            sort_by: "timestamp",
+            order: "desc",
+            limit: 500,
+            cursor: cursor
+          }
+        });
+        all = all.concat(response.data.benchmarks || []);
+        cursor = response.data.next_cursor || '';
+      } while (cursor);
+      setAllBenchmarks(all);
+      setTotalBenchmarks(all.length);
     } catch (error) {
       console.error('Error fetching all benchmarks:', error);
     }